@@ -0,0 +1,187 @@
+package golangIPSentry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	abuseIPDBFreeLimit = 1000  // * free tier daily check quota
+	abuseIPDBPaidLimit = 10000 // * basic plan daily check quota
+	abuseIPDBCacheTTL  = 24 * time.Hour
+	abuseIPDBBackoff   = time.Minute
+	abuseIPDBTimeout   = 2 * time.Second // * scorer timeout, the HTTP call needs more than defaultScorerTimeout
+)
+
+type abuseIPDBResponse struct {
+	Data struct {
+		IPAddress            string `json:"ipAddress"`
+		AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+		TotalReports         int    `json:"totalReports"`
+	} `json:"data"`
+}
+
+func (i *IPGuardian) newAbuseIPDBApi() *AbuseIPDBApi {
+	if i.Config.AbuseIPDBToken == "" {
+		return nil
+	}
+
+	baseURL := defaultAbuseIPDBApi
+	if i.Config.AbuseIPDBApi != "" {
+		baseURL = strings.TrimRight(i.Config.AbuseIPDBApi, "/")
+	}
+
+	timeout := i.Config.AbuseIPDBTimeout
+	if timeout <= 0 {
+		timeout = abuseIPDBTimeout
+	}
+
+	return &AbuseIPDBApi{
+		Context: i.Context,
+		IsPaid:  i.Config.AbuseIPDBIsPaid,
+		Token:   i.Config.AbuseIPDBToken,
+		BaseURL: baseURL,
+		Timeout: timeout,
+		Store:   i.Store,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+		Logger:  i.Logger,
+	}
+}
+
+// * reserve one request from the daily quota, shared by every instance on the Store
+func (a *AbuseIPDBApi) acquire(ctx context.Context) (bool, error) {
+	backoff, err := a.Store.Exists(ctx, redisAbuseIPDBBackoff)
+	if err != nil || backoff {
+		return false, err
+	}
+
+	key := fmt.Sprintf(redisAbuseIPDBQuota, time.Now().UTC().Format(time.DateOnly))
+	count, err := a.Store.Incr(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		a.Store.Expire(ctx, key, 25*time.Hour)
+	}
+
+	return int(count) <= a.limit(), nil
+}
+
+// * quota is used up on the server side, stop until the next UTC day
+func (a *AbuseIPDBApi) exhaust() {
+	now := time.Now().UTC()
+	key := fmt.Sprintf(redisAbuseIPDBQuota, now.Format(time.DateOnly))
+	next := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	if err := a.Store.Set(a.Context, key, a.limit(), next.Sub(now)); err != nil {
+		a.Logger.WarnError(err, "Failed to save AbuseIPDB quota")
+	}
+}
+
+// * failed requests are not retried for every IP until the backoff expires
+// * a check that ran out of time says nothing about AbuseIPDB, no backoff
+func (a *AbuseIPDBApi) fail(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	// * the request ctx may be done already
+	if err := a.Store.Set(a.Context, redisAbuseIPDBBackoff, 1, abuseIPDBBackoff); err != nil {
+		a.Logger.WarnError(err, "Failed to save AbuseIPDB backoff")
+	}
+	return err
+}
+
+func (a *AbuseIPDBApi) limit() int {
+	if a.IsPaid {
+		return abuseIPDBPaidLimit
+	}
+	return abuseIPDBFreeLimit
+}
+
+// * return abuse confidence score (0-100), -1 when skipped by quota
+func (a *AbuseIPDBApi) check(ctx context.Context, ip string) (int, error) {
+	key := fmt.Sprintf(redisAbuseIPDB, ip)
+
+	if cache, err := a.Store.Get(ctx, key); err == nil {
+		if score, err := strconv.Atoi(cache); err == nil {
+			return score, nil
+		}
+	}
+
+	if ok, err := a.acquire(ctx); !ok {
+		return -1, err
+	}
+
+	query := url.Values{}
+	query.Set("ipAddress", ip)
+	query.Set("maxAgeInDays", "90")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BaseURL+"/check?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Key", a.Token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return 0, a.fail(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		a.exhaust()
+		return -1, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, a.fail(fmt.Errorf("AbuseIPDB responded with status %d", resp.StatusCode))
+	}
+
+	var body abuseIPDBResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, a.fail(err)
+	}
+
+	score := body.Data.AbuseConfidenceScore
+	if err := a.Store.Set(ctx, key, score, abuseIPDBCacheTTL); err != nil {
+		a.Logger.WarnError(err, "Failed to cache AbuseIPDB score")
+	}
+
+	return score, nil
+}
+
+func (i *IPGuardian) calcAbuseIPDB(ctx context.Context, p *policy, device *Device, flags *[]string, score *RiskScore) error {
+	if err := validateDevice(device); err != nil {
+		return err
+	}
+
+	if i.AbuseIPDBApi == nil || device.Is.Internal {
+		return nil
+	}
+
+	confidence, err := i.AbuseIPDBApi.check(ctx, device.IP.Address)
+	if err != nil {
+		// * reputation is optional, do not fail the whole score
+		i.Logger.WarnError(err, "Failed to check AbuseIPDB")
+		return nil
+	}
+
+	if confidence <= 0 {
+		return nil
+	}
+
+	*flags = append(*flags, "abuseipdb_reported")
+	score.Base += confidence * p.ScoreAbuseIPDB / 100
+	score.Detail["abuseConfidence"] = confidence
+
+	return nil
+}
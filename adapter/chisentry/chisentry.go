@@ -0,0 +1,12 @@
+package chisentry
+
+import (
+	"net/http"
+
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+)
+
+// * chi middleware is plain net/http, e.g. router.Use(chisentry.Middleware(guardian))
+func Middleware(guardian *golangIPSentry.IPGuardian) func(http.Handler) http.Handler {
+	return guardian.HTTPMiddleware
+}
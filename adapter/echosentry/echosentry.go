@@ -0,0 +1,21 @@
+package echosentry
+
+import (
+	"github.com/labstack/echo/v4"
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+)
+
+// * rejections are written by Config.ErrorHandler and the handler is not called
+func Middleware(guardian *golangIPSentry.IPGuardian) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			check := guardian.Check(c.Request(), c.Response())
+			if !check.Success {
+				guardian.WriteError(c.Response(), c.Request(), check)
+				return nil
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package fasthttpsentry
+
+import (
+	"net/http"
+
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+var sameSite = map[string]fasthttp.CookieSameSite{
+	"Strict": fasthttp.CookieSameSiteStrictMode,
+	"Lax":    fasthttp.CookieSameSiteLaxMode,
+	"None":   fasthttp.CookieSameSiteNoneMode,
+}
+
+type request struct {
+	ctx *fasthttp.RequestCtx
+}
+
+// * golangIPSentry.Request of a fasthttp request
+func NewRequest(ctx *fasthttp.RequestCtx) golangIPSentry.Request {
+	return &request{
+		ctx: ctx,
+	}
+}
+
+func (r *request) Method() string {
+	return string(r.ctx.Method())
+}
+
+func (r *request) Path() string {
+	return string(r.ctx.Path())
+}
+
+func (r *request) RemoteAddr() string {
+	return r.ctx.RemoteAddr().String()
+}
+
+func (r *request) Header(key string) string {
+	return string(r.ctx.Request.Header.Peek(key))
+}
+
+func (r *request) HeaderValues(key string) []string {
+	raw := r.ctx.Request.Header.PeekAll(key)
+	values := make([]string, len(raw))
+	for idx, value := range raw {
+		values[idx] = string(value)
+	}
+	return values
+}
+
+func (r *request) Cookie(name string) string {
+	return string(r.ctx.Request.Header.Cookie(name))
+}
+
+func (r *request) SetHeader(key, value string) {
+	r.ctx.Response.Header.Set(key, value)
+}
+
+func (r *request) SetCookie(cookie golangIPSentry.Cookie) {
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+
+	c.SetKey(cookie.Name)
+	c.SetValue(cookie.Value)
+	c.SetPath(cookie.Path)
+	c.SetMaxAge(cookie.MaxAge)
+	c.SetHTTPOnly(true)
+	c.SetSecure(cookie.Secure)
+	c.SetSameSite(sameSite[cookie.SameSite])
+	r.ctx.Response.Header.SetCookie(c)
+}
+
+func Check(guardian *golangIPSentry.IPGuardian, ctx *fasthttp.RequestCtx) golangIPSentry.IPGuardianResult {
+	return guardian.CheckRequest(NewRequest(ctx))
+}
+
+// * Config.ErrorHandler is a net/http handler, run it against a converted request
+func WriteError(guardian *golangIPSentry.IPGuardian, ctx *fasthttp.RequestCtx, result golangIPSentry.IPGuardianResult) {
+	var r http.Request
+	if err := fasthttpadaptor.ConvertRequest(ctx, &r, true); err != nil {
+		ctx.SetStatusCode(result.StatusCode)
+		return
+	}
+
+	guardian.WriteError(&responseWriter{ctx: ctx}, r.WithContext(ctx), result)
+}
+
+// * rejections are written by Config.ErrorHandler and next is not called
+func Middleware(guardian *golangIPSentry.IPGuardian, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		check := Check(guardian, ctx)
+		if !check.Success {
+			WriteError(guardian, ctx, check)
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// * http.ResponseWriter writing into the fasthttp response
+type responseWriter struct {
+	ctx    *fasthttp.RequestCtx
+	header http.Header
+	wrote  bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	for key, values := range w.header {
+		w.ctx.Response.Header.Del(key)
+		for _, value := range values {
+			w.ctx.Response.Header.Add(key, value)
+		}
+	}
+	w.ctx.SetStatusCode(statusCode)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ctx.Write(data)
+}
@@ -0,0 +1,23 @@
+module github.com/pardnchiu/golang-ip-sentry/adapter/fasthttpsentry
+
+go 1.24.3
+
+require (
+	github.com/pardnchiu/golang-ip-sentry v0.0.0-20261017093000-28f71a9d2734
+	github.com/valyala/fasthttp v1.62.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/oschwald/geoip2-golang v1.11.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	github.com/pardnchiu/go-logger v0.2.0 // indirect
+	github.com/redis/go-redis/v9 v9.9.0 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
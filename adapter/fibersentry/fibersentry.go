@@ -0,0 +1,22 @@
+package fibersentry
+
+import (
+	"github.com/gofiber/fiber/v2"
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/fasthttpsentry"
+)
+
+// * fiber runs on fasthttp, rejections are written by Config.ErrorHandler
+func Middleware(guardian *golangIPSentry.IPGuardian) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		check := fasthttpsentry.Check(guardian, ctx)
+		if !check.Success {
+			fasthttpsentry.WriteError(guardian, ctx, check)
+			return nil
+		}
+
+		return c.Next()
+	}
+}
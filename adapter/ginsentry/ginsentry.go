@@ -0,0 +1,20 @@
+package ginsentry
+
+import (
+	"github.com/gin-gonic/gin"
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+)
+
+// * rejections are written by Config.ErrorHandler and abort the chain
+func Middleware(guardian *golangIPSentry.IPGuardian) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		check := guardian.Check(c.Request, c.Writer)
+		if !check.Success {
+			guardian.WriteError(c.Writer, c.Request, check)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,155 @@
+package grpcsentry
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// * gRPC calls are HTTP/2 POST requests, routes match the full method, e.g. "/pkg.Service/Method"
+type request struct {
+	ctx      context.Context
+	method   string
+	incoming metadata.MD
+	outgoing metadata.MD
+}
+
+// * golangIPSentry.Request of a gRPC call
+// * session and device id are read from metadata and returned as header metadata, clients echo them to keep a session
+func NewRequest(ctx context.Context, fullMethod string) golangIPSentry.Request {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+
+	return &request{
+		ctx:      ctx,
+		method:   fullMethod,
+		incoming: incoming,
+		outgoing: metadata.MD{},
+	}
+}
+
+func (r *request) Method() string {
+	return http.MethodPost
+}
+
+func (r *request) Path() string {
+	return r.method
+}
+
+func (r *request) RemoteAddr() string {
+	p, ok := peer.FromContext(r.ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
+}
+
+func (r *request) Header(key string) string {
+	values := r.incoming.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (r *request) HeaderValues(key string) []string {
+	return r.incoming.Get(key)
+}
+
+func (r *request) Cookie(name string) string {
+	return r.Header(name)
+}
+
+func (r *request) SetHeader(key, value string) {
+	r.outgoing.Set(key, value)
+}
+
+func (r *request) SetCookie(cookie golangIPSentry.Cookie) {
+	r.outgoing.Set(cookie.Name, cookie.Value)
+}
+
+// * header metadata is sent with the response or the rejection
+func check(guardian *golangIPSentry.IPGuardian, ctx context.Context, fullMethod string, setHeader func(metadata.MD) error) error {
+	req := NewRequest(ctx, fullMethod).(*request)
+	result := guardian.CheckRequest(req)
+
+	if len(req.outgoing) > 0 {
+		setHeader(req.outgoing)
+	}
+
+	if result.Success {
+		return nil
+	}
+	return Error(result)
+}
+
+// * status of a rejected result, with ErrorInfo and RetryInfo when the wait is known
+func Error(result golangIPSentry.IPGuardianResult) error {
+	code := codes.PermissionDenied
+	switch result.StatusCode {
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+
+	st := status.New(code, golangIPSentry.PublicError(result))
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: result.Reason,
+		Domain: "ipsentry",
+	}); err == nil {
+		st = withInfo
+	}
+
+	var retryAfter time.Duration
+	switch {
+	case result.StatusCode == http.StatusTooManyRequests && result.RateLimit != nil:
+		retryAfter = result.RateLimit.RetryAfter
+	case result.BlockTTL > 0:
+		retryAfter = result.BlockTTL
+	}
+	if retryAfter > 0 {
+		if withRetry, err := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		}); err == nil {
+			st = withRetry
+		}
+	}
+
+	return st.Err()
+}
+
+// * rejected calls return the status of Error and the handler is not called
+func UnaryServerInterceptor(guardian *golangIPSentry.IPGuardian) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		setHeader := func(md metadata.MD) error {
+			return grpc.SetHeader(ctx, md)
+		}
+		if err := check(guardian, ctx, info.FullMethod, setHeader); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// * checked once when the stream opens
+func StreamServerInterceptor(guardian *golangIPSentry.IPGuardian) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(guardian, stream.Context(), info.FullMethod, stream.SetHeader); err != nil {
+			return err
+		}
+
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,442 @@
+package golangIPSentry
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// * current state of an IP, returned by Inspect
+type IPState struct {
+	IP              string        `json:"ip"`
+	Allow           *IPItem       `json:"allow,omitempty"`
+	Deny            *IPItem       `json:"deny,omitempty"`
+	Block           *IPItem       `json:"block,omitempty"`
+	BlockTTL        time.Duration `json:"block_ttl,omitempty"`
+	BlockCount      int           `json:"block_count"`                // * requests while blocked in the last hour
+	RequestCount    int           `json:"request_count"`              // * requests counted by the normal rate limit window
+	DeviceCount     int           `json:"device_count"`               // * devices seen in the last hour
+	AbuseConfidence *int          `json:"abuse_confidence,omitempty"` // * cached AbuseIPDB score
+	Score           *ScoreItem    `json:"score,omitempty"`            // * last computed score
+}
+
+// * shared by allow, deny and block managers
+type adminList interface {
+	Add(ip string, reason string) error
+	Remove(ip string) error
+	Get(ip string) (*IPItem, error)
+	List(page, size int) ([]IPItem, int, error)
+}
+
+type adminListBody struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+type adminListResponse struct {
+	Items []IPItem `json:"items"`
+	Total int      `json:"total"`
+	Page  int      `json:"page"`
+	Size  int      `json:"size"`
+}
+
+type adminPolicyResponse struct {
+	Version   int64     `json:"version"`
+	Parameter Parameter `json:"parameter"`
+}
+
+// * read counters without increasing them
+func (i *IPGuardian) Inspect(ip string) (*IPState, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP: %w", err)
+	}
+	ip = addr.Unmap().String()
+
+	state := &IPState{
+		IP: ip,
+	}
+
+	if state.Allow, err = i.Manager.Allow.Get(ip); err != nil {
+		return nil, err
+	}
+	if state.Deny, err = i.Manager.Deny.Get(ip); err != nil {
+		return nil, err
+	}
+	if state.Block, err = i.Manager.Block.Get(ip); err != nil {
+		return nil, err
+	}
+	if state.BlockTTL, err = i.Manager.Block.TTL(ip); err != nil {
+		return nil, err
+	}
+
+	p := i.policy.Load()
+	if rate, err := i.rateLimit(p, ip, nil, p.RateLimitNormal, false); err == nil {
+		state.RequestCount = rate.Count
+	}
+
+	pipe := i.Store.Pipeline()
+	blockCmd := pipe.Get(i.Context, fmt.Sprintf(redisBlockCount, ip))
+	deviceCmd := pipe.SCard(i.Context, fmt.Sprintf(redisIPDevice, ip))
+	abuseCmd := pipe.Get(i.Context, fmt.Sprintf(redisAbuseIPDB, ip))
+	scoreCmd := pipe.Get(i.Context, fmt.Sprintf(redisScoreLast, ip))
+
+	if err := pipe.Exec(i.Context); err != nil {
+		return nil, i.Logger.Error(err, "Failed to inspect IP")
+	}
+
+	if count, err := blockCmd.Int(); err == nil {
+		state.BlockCount = int(count)
+	}
+	if count, err := deviceCmd.Int(); err == nil {
+		state.DeviceCount = int(count)
+	}
+	if value, err := abuseCmd.String(); err == nil {
+		if confidence, err := strconv.Atoi(value); err == nil {
+			state.AbuseConfidence = &confidence
+		}
+	}
+	if value, err := scoreCmd.String(); err == nil {
+		var score ScoreItem
+		if err := json.Unmarshal([]byte(value), &score); err == nil {
+			state.Score = &score
+		}
+	}
+
+	return state, nil
+}
+
+// * reset rate limit state of the IP, return removed key count
+func (i *IPGuardian) ClearRateLimit(ip string) (int64, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IP: %w", err)
+	}
+
+	ip = addr.Unmap().String()
+
+	// * route counters of every algorithm
+	keys, err := i.Store.Scan(i.Context, fmt.Sprintf(redisRateLimitRoute, "*", "*", ip))
+	if err != nil {
+		return 0, i.Logger.Error(err, "Failed to scan rate limit keys")
+	}
+	// * state of every algorithm, the algorithm may have been changed
+	for _, algorithm := range []string{RateLimitSlidingLog, RateLimitSlidingCounter, RateLimitGCRA} {
+		keys = append(keys, fmt.Sprintf(redisRateLimit, algorithm, ip))
+	}
+
+	count, err := i.Store.Del(i.Context, keys...)
+	if err != nil {
+		return 0, i.Logger.Error(err, "Failed to clear rate limit")
+	}
+
+	return count, nil
+}
+
+// * mount with http.StripPrefix, e.g. mux.Handle("/admin/", http.StripPrefix("/admin", guardian.AdminHandler()))
+func (i *IPGuardian) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	lists := map[string]adminList{
+		"allow": i.Manager.Allow,
+		"deny":  i.Manager.Deny,
+		"block": i.Manager.Block,
+	}
+
+	for name, list := range lists {
+		mux.HandleFunc("GET /"+name, i.adminList(list))
+		mux.HandleFunc("POST /"+name, i.adminAdd(name, list))
+		mux.HandleFunc("GET /"+name+"/{ip...}", i.adminGet(name, list))
+		mux.HandleFunc("DELETE /"+name+"/{ip...}", i.adminRemove(name, list))
+	}
+
+	mux.HandleFunc("GET /ip/{ip}", i.adminInspect)
+	mux.HandleFunc("DELETE /ip/{ip}/rate-limit", i.adminClearRateLimit)
+	mux.HandleFunc("GET /policy", i.adminPolicy)
+	mux.HandleFunc("PATCH /policy", i.adminUpdatePolicy)
+	mux.HandleFunc("GET /policy/history", i.adminPolicyHistory)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !i.adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (i *IPGuardian) adminAuthorized(r *http.Request) bool {
+	config := i.Config.Admin
+	if config == nil {
+		return false
+	}
+
+	if config.Token != "" {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if found && subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) == 1 {
+			return true
+		}
+	}
+
+	// * server must set tls.Config.ClientAuth to verify client certificates
+	if config.MTLS && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if len(config.ClientNames) == 0 {
+			return true
+		}
+
+		leaf := r.TLS.VerifiedChains[0][0]
+		for _, name := range config.ClientNames {
+			if leaf.Subject.CommonName == name || slices.Contains(leaf.DNSNames, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// * certificate name for mTLS, recorded in PolicyUpdate.Actor
+func adminActor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		leaf := r.TLS.VerifiedChains[0][0]
+		if leaf.Subject.CommonName != "" {
+			return "admin:" + leaf.Subject.CommonName
+		}
+		if len(leaf.DNSNames) > 0 {
+			return "admin:" + leaf.DNSNames[0]
+		}
+	}
+	return "admin:token"
+}
+
+// * block entries are single IPs, allow and deny also accept CIDR and range
+func adminEntry(name, ip string) (string, error) {
+	if name == "block" {
+		return adminIP(ip)
+	}
+
+	entry, _, err := parseIPEntry(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return entry, nil
+}
+
+func adminIP(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP: %s", ip)
+	}
+	return addr.Unmap().String(), nil
+}
+
+func (i *IPGuardian) adminList(list adminList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if page <= 0 {
+			page = 1
+		}
+		if size <= 0 {
+			size = defaultPageSize
+		}
+
+		items, total, err := list.List(page, size)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeAdminJSON(w, http.StatusOK, adminListResponse{
+			Items: items,
+			Total: total,
+			Page:  page,
+			Size:  size,
+		})
+	}
+}
+
+func (i *IPGuardian) adminAdd(name string, list adminList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body adminListBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		ip, err := adminEntry(name, strings.TrimSpace(body.IP))
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := list.Add(ip, body.Reason); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		item, err := list.Get(ip)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		i.Logger.Info("Admin added "+name+" entry", "ip: "+ip, "reason: "+body.Reason)
+
+		writeAdminJSON(w, http.StatusCreated, item)
+	}
+}
+
+func (i *IPGuardian) adminGet(name string, list adminList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, err := adminEntry(name, r.PathValue("ip"))
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		item, err := list.Get(ip)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if item == nil {
+			writeAdminError(w, http.StatusNotFound, ip+" is not found")
+			return
+		}
+
+		writeAdminJSON(w, http.StatusOK, item)
+	}
+}
+
+func (i *IPGuardian) adminRemove(name string, list adminList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, err := adminEntry(name, r.PathValue("ip"))
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := list.Remove(ip); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			writeAdminError(w, status, err.Error())
+			return
+		}
+
+		i.Logger.Info("Admin removed "+name+" entry", "ip: "+ip)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (i *IPGuardian) adminInspect(w http.ResponseWriter, r *http.Request) {
+	ip, err := adminIP(r.PathValue("ip"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	state, err := i.Inspect(ip)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, state)
+}
+
+func (i *IPGuardian) adminClearRateLimit(w http.ResponseWriter, r *http.Request) {
+	ip, err := adminIP(r.PathValue("ip"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count, err := i.ClearRateLimit(ip)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	i.Logger.Info("Admin cleared rate limit", "ip: "+ip)
+
+	writeAdminJSON(w, http.StatusOK, map[string]int64{
+		"cleared": count,
+	})
+}
+
+func (i *IPGuardian) adminPolicy(w http.ResponseWriter, r *http.Request) {
+	p := i.policy.Load()
+
+	writeAdminJSON(w, http.StatusOK, adminPolicyResponse{
+		Version:   p.version,
+		Parameter: p.view(),
+	})
+}
+
+// * fields in the body replace the ones of the current policy
+// * decoded over the policy as given, unset fields keep following the defaults
+func (i *IPGuardian) adminUpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	base := i.policy.Load().raw
+	base.HighRiskCountry = append([]string(nil), base.HighRiskCountry...)
+	parameter, err := decodeParameter(body, base)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateParameter(parameter); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update, err := i.UpdatePolicy(parameter, adminActor(r))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, update)
+}
+
+func (i *IPGuardian) adminPolicyHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := i.PolicyHistory()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, history)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{
+		"error": message,
+	})
+}
@@ -4,29 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/netip"
 	"os"
+	"sort"
 	"sync"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
 type AllowIPManager struct {
 	Logger  *Logger
 	Config  *Config
-	Redis   *redis.Client
+	Store   Store
 	Context context.Context
 	Mutex   sync.RWMutex
 	Cache   map[string]*IPItem
+	trie    *ipTrie
+	file    listFile
 }
 
 func (i *IPGuardian) newAllowManager() *AllowIPManager {
 	manager := &AllowIPManager{
 		Logger:  i.Logger,
 		Config:  i.Config,
-		Redis:   i.Redis,
+		Store:   i.Store,
 		Context: i.Context,
 		Cache:   make(map[string]*IPItem),
+		trie:    newIPTrie(),
 	}
 
 	err := manager.load()
@@ -41,22 +44,23 @@ func (m *AllowIPManager) load() error {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
-	path := defaultWhiteListPath
-	// * custom path is exist, use it
-	if m.Config.Filepath.WhiteList != "" {
-		path = m.Config.Filepath.WhiteList
-	}
+	path := m.Path()
 
 	// * file is not exist, skip importing
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	data, err := os.ReadFile(path)
 	// * failed to read file, stop importing
 	if err != nil {
 		return err
 	}
+	m.file = newListFile(info, data)
 
 	var list []IPItem
 	// * failed to parse json, stop importing
@@ -64,66 +68,208 @@ func (m *AllowIPManager) load() error {
 		return err
 	}
 
-	pipe := m.Redis.Pipeline()
+	_, err = m.insert(list)
+	return err
+}
+
+// * caller must hold the mutex, return inserted count
+func (m *AllowIPManager) insert(list []IPItem) (int, error) {
+	pipe := m.Store.Pipeline()
+	items := make([]*IPItem, 0, len(list))
+	entries := make([][]netip.Prefix, 0, len(list))
 
 	for _, item := range list {
+		entry, prefixes, err := parseIPEntry(item.IP)
+		// * invalid IP, CIDR or range, skip this item
+		if err != nil {
+			m.Logger.WarnError(err, "Skip invalid white list entry")
+			continue
+		}
+		item.IP = entry
+
 		data, err := json.Marshal(item)
 		// * failed to parse item, skip this item
 		if err != nil {
 			continue
 		}
-		// * add item to memory cache
-		m.Cache[item.IP] = &item
 
 		key := fmt.Sprintf(redisAllow, item.IP)
 		pipe.Set(m.Context, key, data, 0)
+		items = append(items, &item)
+		entries = append(entries, prefixes)
 	}
 
-	_, err = pipe.Exec(m.Context)
+	err := pipe.Exec(m.Context)
 	// * failed to execute pipeline, stop importing
 	if err != nil {
-		return m.Logger.Error(err, "Failed to store white list to redis")
+		return 0, m.Logger.Error(err, "Failed to store white list to redis")
 	}
 
-	return nil
+	// * add items to memory cache once stored, a failed write is not matched
+	for idx, item := range items {
+		m.Cache[item.IP] = item
+		for _, prefix := range entries[idx] {
+			m.trie.insert(prefix, item)
+		}
+	}
+
+	return len(items), nil
+}
+
+// * bulk add in list file format, no notification is sent
+func (m *AllowIPManager) Import(list []IPItem) (int, error) {
+	now := time.Now().UTC().Unix()
+	items := make([]IPItem, len(list))
+	for idx, item := range list {
+		if item.AddedAt == 0 {
+			item.AddedAt = now
+		}
+		items[idx] = item
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	count, err := m.insert(items)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.save(); err != nil {
+		return count, m.Logger.Error(err, "Failed to save white list to file")
+	}
+
+	return count, nil
 }
 
 func (m *AllowIPManager) Check(ip string) bool {
+	item, _ := m.match(ip)
+	return item != nil
+}
+
+// * entry in the Store first, then the entries in memory covering the IP
+// * error of the Store when nothing matched, entries in memory still match without it
+func (m *AllowIPManager) match(ip string) (*IPItem, error) {
 	key := fmt.Sprintf(redisAllow, ip)
-	exist, err := m.Redis.Exists(m.Context, key).Result()
-	if err == nil && exist > 0 {
-		return true
+	data, storeErr := m.Store.Get(m.Context, key)
+	if storeErr == nil {
+		var item IPItem
+		if err := json.Unmarshal([]byte(data), &item); err != nil || item.IP == "" {
+			// * still a match, only the detail is unknown
+			item = IPItem{IP: ip}
+		}
+		return &item, nil
+	}
+	if storeErr == ErrNil {
+		storeErr = nil
 	}
 
 	m.Mutex.RLock()
 	defer m.Mutex.RUnlock()
 
-	_, cache := m.Cache[ip]
+	// * match single IP, CIDR and range entries
+	item, ok := m.Cache[ip]
+	if !ok {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			item = m.trie.lookup(addr)
+		}
+	}
+	if item == nil {
+		return nil, storeErr
+	}
 
-	return cache
+	copied := *item
+	return &copied, nil
 }
 
-// * save white list to file
-func (m *AllowIPManager) save() error {
-	path := defaultWhiteListPath
+// * list file read, written and watched by the manager
+func (m *AllowIPManager) Path() string {
 	if m.Config.Filepath.WhiteList != "" {
-		path = m.Config.Filepath.WhiteList
+		return m.Config.Filepath.WhiteList
 	}
+	return defaultWhiteListPath
+}
 
-	var list []IPItem
+// * save white list to file
+func (m *AllowIPManager) save() error {
+	path := m.Path()
+
+	list := make([]IPItem, 0, len(m.Cache))
 	for _, item := range m.Cache {
 		list = append(list, *item)
 	}
+	sortIPItems(list)
 
 	data, err := json.MarshalIndent(list, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	// * own writes are not reloaded
+	if info, err := os.Stat(path); err == nil {
+		m.file = newListFile(info, data)
+	}
+	return nil
+}
+
+// * apply changes of the list file, the current list is kept when the file is invalid
+func (m *AllowIPManager) reload() {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	path := m.Path()
+
+	list, changed, err := m.file.read(path)
+	if err != nil {
+		m.Logger.Error(err, "Failed to reload white list, keep the last version")
+		return
+	}
+	if !changed {
+		return
+	}
+
+	cache, diff := diffIPItems(m.Cache, list)
+	if diff.empty() {
+		return
+	}
+
+	pipe := m.Store.Pipeline()
+	for _, entries := range [][]string{diff.Added, diff.Changed} {
+		for _, entry := range entries {
+			data, err := json.Marshal(cache[entry])
+			if err != nil {
+				continue
+			}
+			pipe.Set(m.Context, fmt.Sprintf(redisAllow, entry), data, 0)
+		}
+	}
+	for _, entry := range diff.Removed {
+		pipe.Del(m.Context, fmt.Sprintf(redisAllow, entry))
+	}
+	if err := pipe.Exec(m.Context); err != nil {
+		// * retry on the next poll
+		m.file = listFile{}
+		m.Logger.Error(err, "Failed to store white list to redis, keep the last version")
+		return
+	}
+
+	m.Cache = cache
+	m.rebuild()
+
+	m.Logger.Info(diff.messages("White list reloaded")...)
 }
 
 func (m *AllowIPManager) Add(ip string, tag string) error {
+	entry, prefixes, err := parseIPEntry(ip)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to parse white ip")
+	}
+	ip = entry
+
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
@@ -133,21 +279,128 @@ func (m *AllowIPManager) Add(ip string, tag string) error {
 		AddedAt: time.Now().UTC().Unix(),
 	}
 
-	m.Cache[ip] = item
-
 	data, err := json.Marshal(item)
 	if err != nil {
 		return m.Logger.Error(err, "Failed to parse white ip")
 	}
 
 	key := fmt.Sprintf(redisAllow, ip)
-	if err := m.Redis.Set(m.Context, key, data, 0).Err(); err != nil {
+	if err := m.Store.Set(m.Context, key, data, 0); err != nil {
 		return m.Logger.Error(err, "Failed to store white ip to redis")
 	}
 
+	// * matched only once stored
+	m.Cache[ip] = item
+	for _, prefix := range prefixes {
+		m.trie.insert(prefix, item)
+	}
+
 	if err := m.save(); err != nil {
 		return m.Logger.Error(err, "Failed to save white ip to file")
 	}
 
 	return nil
 }
+
+func (m *AllowIPManager) Remove(ip string) error {
+	entry, prefixes, err := parseIPEntry(ip)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to parse white ip")
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	key := fmt.Sprintf(redisAllow, entry)
+	count, err := m.Store.Del(m.Context, key)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to remove white ip from redis")
+	}
+
+	if _, ok := m.Cache[entry]; !ok {
+		if count == 0 {
+			return fmt.Errorf("%w: %s is not in white list", ErrNotFound, entry)
+		}
+		return nil
+	}
+
+	delete(m.Cache, entry)
+	for _, prefix := range prefixes {
+		m.trie.remove(prefix, entry)
+	}
+
+	if err := m.save(); err != nil {
+		return m.Logger.Error(err, "Failed to save white list to file")
+	}
+
+	return nil
+}
+
+// * same lookup as Check, nil when the IP does not match
+// * a CIDR or range returns its own entry
+func (m *AllowIPManager) Get(ip string) (*IPItem, error) {
+	entry, _, err := parseIPEntry(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.match(entry)
+}
+
+// * page starts from 1, return items of the page and total count
+func (m *AllowIPManager) List(page, size int) ([]IPItem, int, error) {
+	m.Mutex.RLock()
+	list := make([]IPItem, 0, len(m.Cache))
+	for _, item := range m.Cache {
+		list = append(list, *item)
+	}
+	m.Mutex.RUnlock()
+
+	sortIPItems(list)
+
+	return paginateIPItems(list, page, size), len(list), nil
+}
+
+func sortIPItems(list []IPItem) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AddedAt != list[j].AddedAt {
+			return list[i].AddedAt > list[j].AddedAt
+		}
+		return list[i].IP < list[j].IP
+	})
+}
+
+func paginateIPItems(list []IPItem, page, size int) []IPItem {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = defaultPageSize
+	}
+
+	start := (page - 1) * size
+	if start >= len(list) {
+		return []IPItem{}
+	}
+
+	end := start + size
+	if end > len(list) {
+		end = len(list)
+	}
+
+	return list[start:end]
+}
+
+func (m *AllowIPManager) rebuild() {
+	trie := newIPTrie()
+	for entry, item := range m.Cache {
+		_, prefixes, err := parseIPEntry(entry)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range prefixes {
+			trie.insert(prefix, item)
+		}
+	}
+	m.trie = trie
+}
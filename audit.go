@@ -0,0 +1,254 @@
+package golangIPSentry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// * action of an audit entry
+const (
+	AuditBlock      = "block"
+	AuditBan        = "ban"
+	AuditRateLimit  = "rate_limit"
+	AuditTrust      = "trust"
+	AuditSuspicious = "suspicious"
+	AuditDegraded   = "degraded" // * decided by Config.Failure, the Store was unavailable
+	AuditPolicy     = "policy"   // * PolicyUpdate, written by the instance that made it
+)
+
+// * one JSON line per decision
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	Reason        string    `json:"reason"`
+	StatusCode    int       `json:"status_code"`
+	IP            string    `json:"ip"`
+	SessionID     string    `json:"session_id,omitempty"`
+	Fingerprint   string    `json:"fingerprint,omitempty"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Route         string    `json:"route,omitempty"`
+	PolicyVersion int64     `json:"policy_version"`
+	Score         int       `json:"score"`
+	Flags         []string  `json:"flags,omitempty"`
+	DryRun        bool      `json:"dry_run,omitempty"` // * monitor mode, not enforced
+}
+
+type auditLog struct {
+	writer io.Writer
+	file   *auditFile // * default sink, closed with the guardian
+	mutex  sync.Mutex
+}
+
+func newAuditLog(config *AuditConfig, log *Log) (*auditLog, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	if config.Writer != nil {
+		return &auditLog{
+			writer: config.Writer,
+		}, nil
+	}
+
+	// * same rotation settings as Log unless overridden
+	file := &auditFile{
+		path:      config.Path,
+		stdout:    config.Stdout,
+		maxSize:   config.MaxSize,
+		maxBackup: config.MaxBackup,
+	}
+	if file.path == "" {
+		file.path = filepath.Join(log.Path, "audit")
+	}
+	if file.maxSize <= 0 {
+		file.maxSize = log.MaxSize
+	}
+	if file.maxBackup <= 0 {
+		file.maxBackup = log.MaxBackup
+	}
+	file.path = filepath.Join(file.path, "output.log")
+
+	if err := file.open(); err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &auditLog{
+		writer: file,
+		file:   file,
+	}, nil
+}
+
+func (a *auditLog) write(entry any) {
+	if a == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.writer.Write(data)
+}
+
+func (a *auditLog) writePolicy(update PolicyUpdate) {
+	a.write(struct {
+		Action string `json:"action"`
+		PolicyUpdate
+	}{AuditPolicy, update})
+}
+
+func (a *auditLog) close() {
+	if a == nil || a.file == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.file.close()
+}
+
+// * output.log of raw JSON lines, rotated by size to output.log.YYYYMMDD_HHMMSS.micro
+// * callers serialize writes through auditLog.mutex
+type auditFile struct {
+	path      string
+	stdout    bool
+	maxSize   int64
+	maxBackup int
+	file      *os.File
+	size      int64
+}
+
+func (f *auditFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *auditFile) Write(data []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// * a line is never split across files
+	if f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	if f.stdout {
+		os.Stdout.Write(data)
+	}
+	return n, err
+}
+
+func (f *auditFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	backup := f.path + "." + time.Now().Format("20060102_150405.000000")
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	// * backup names sort by time, keep the newest maxBackup
+	backups, err := filepath.Glob(f.path + ".*")
+	if err == nil && len(backups) > f.maxBackup {
+		sort.Strings(backups)
+		for _, path := range backups[:len(backups)-f.maxBackup] {
+			os.Remove(path)
+		}
+	}
+
+	return f.open()
+}
+
+func (f *auditFile) close() {
+	if f.file == nil {
+		return
+	}
+	f.file.Close()
+	f.file = nil
+}
+
+// * block, ban, rate limit, trust bypass, suspicious scores and degraded checks, other results are not recorded
+func auditAction(reason string, suspicious bool) string {
+	switch reason {
+	case ReasonBlockList, ReasonScoreBlock:
+		return AuditBlock
+	case ReasonDenyList, ReasonBlockToBan:
+		return AuditBan
+	case ReasonRateNormal, ReasonRateSuspicious, ReasonRateDangerous:
+		return AuditRateLimit
+	case ReasonAllowList:
+		return AuditTrust
+	case ReasonFailOpen, ReasonFailClosed, ReasonFailLocal:
+		return AuditDegraded
+	case ReasonPass:
+		if suspicious {
+			return AuditSuspicious
+		}
+	}
+	return ""
+}
+
+func (i *IPGuardian) auditCheck(req Request, result IPGuardianResult) {
+	if i.audit == nil {
+		return
+	}
+
+	statusCode, reason := result.StatusCode, result.Reason
+	if result.Decision != nil {
+		statusCode, reason = result.Decision.StatusCode, result.Decision.Reason
+	}
+
+	action := auditAction(reason, result.Suspicious)
+	if action == "" {
+		return
+	}
+
+	i.audit.write(AuditEntry{
+		Time:          time.Now().UTC(),
+		Action:        action,
+		Reason:        reason,
+		StatusCode:    statusCode,
+		IP:            result.IP,
+		SessionID:     result.SessionID,
+		Fingerprint:   result.Fingerprint,
+		Method:        req.Method(),
+		Path:          req.Path(),
+		Route:         result.Route,
+		PolicyVersion: result.PolicyVersion,
+		Score:         result.Score,
+		Flags:         result.Flags,
+		DryRun:        result.DryRun,
+	})
+}
@@ -4,57 +4,50 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
 type BlockIPManager struct {
-	Logger  *Logger
-	Config  *Config
-	Redis   *redis.Client
-	Context context.Context
+	Logger   *Logger
+	Config   *Config
+	Store    Store
+	Context  context.Context
+	notifier *notifyDispatcher
+	policy   *atomic.Pointer[policy]
 }
 
 func (i *IPGuardian) newBlocIPkManager() *BlockIPManager {
 	return &BlockIPManager{
-		Logger:  i.Logger,
-		Config:  i.Config,
-		Redis:   i.Redis,
-		Context: i.Context,
+		Logger:   i.Logger,
+		Config:   i.Config,
+		Store:    i.Store,
+		Context:  i.Context,
+		notifier: i.notifier,
+		policy:   &i.policy,
 	}
 }
 
 func (m *BlockIPManager) IsBlock(ip string) bool {
-	key := fmt.Sprintf(redisBlock, ip)
-
-	exist, err := m.Redis.Exists(m.Context, key).Result()
-	if err == redis.Nil {
-		return false
-	}
-
-	if exist > 0 {
-		return true
-	}
+	exist, _ := m.isBlock(ip)
+	return exist
+}
 
-	return false
+func (m *BlockIPManager) isBlock(ip string) (bool, error) {
+	key := fmt.Sprintf(redisBlock, ip)
+	return m.Store.Exists(m.Context, key)
 }
 
 func (m *BlockIPManager) checkBlockIP(ip string) (bool, *IPItem, error) {
 	key := fmt.Sprintf(redisBlock, ip)
 
-	exists, err := m.Redis.Exists(m.Context, key).Result()
-	if err != nil {
-		return false, nil, err
-	}
-
-	if exists == 0 {
+	data, err := m.Store.Get(m.Context, key)
+	if err == ErrNil {
 		return false, nil, nil
 	}
-
-	data, err := m.Redis.Get(m.Context, key).Result()
 	if err != nil {
-		return true, nil, err
+		return false, nil, err
 	}
 
 	var item IPItem
@@ -77,16 +70,17 @@ func (m *BlockIPManager) Add(ip string, reason string) error {
 		return err
 	}
 
-	var duration time.Duration = time.Duration(m.Config.Parameter.BlockTimeMin) // 默認封鎖時間
+	p := m.policy.Load()
+	var duration time.Duration = p.BlockTimeMin // 默認封鎖時間
 
 	if isBlock && item != nil {
 		item.Reason += "\n" + reason
 		item.Count++
 		item.Last = now
 
-		duration = time.Duration(1<<item.Count) * time.Duration(m.Config.Parameter.BlockTimeMin) // * 指數增長封鎖時間
-		if duration > time.Duration(m.Config.Parameter.BlockTimeMax) {
-			duration = time.Duration(m.Config.Parameter.BlockTimeMax)
+		duration = time.Duration(1<<item.Count) * p.BlockTimeMin // * 指數增長封鎖時間
+		if duration > p.BlockTimeMax {
+			duration = p.BlockTimeMax
 		}
 	} else {
 		item = &IPItem{
@@ -103,9 +97,147 @@ func (m *BlockIPManager) Add(ip string, reason string) error {
 		return m.Logger.Error(err, "Failed to parse block item")
 	}
 
-	if err := m.Redis.Set(m.Context, key, data, duration).Err(); err != nil {
+	if err := m.Store.Set(m.Context, key, data, duration); err != nil {
 		return m.Logger.Error(err, "Failed to update block item in redis")
 	}
 
+	if isBlock {
+		m.notifier.notify(Event{
+			Type:     EventEscalation,
+			IP:       ip,
+			Reason:   reason,
+			Count:    item.Count,
+			Duration: duration,
+		})
+		return nil
+	}
+
+	m.notifier.notify(Event{
+		Type:     EventBlock,
+		IP:       ip,
+		Reason:   reason,
+		Count:    item.Count,
+		Duration: duration,
+	})
+
+	m.checkAttack(p.AttackThreshold)
+
+	return nil
+}
+
+// * notify once when blocked IPs in the current minute reach the threshold
+func (m *BlockIPManager) checkAttack(threshold int) {
+	if threshold <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf(redisAttack, time.Now().UTC().Unix()/60)
+
+	count, err := m.Store.Incr(m.Context, key)
+	if err != nil {
+		m.Logger.WarnError(err, "Failed to count blocked IPs")
+		return
+	}
+
+	if count == 1 {
+		m.Store.Expire(m.Context, key, 2*time.Minute)
+	}
+
+	if int(count) == threshold {
+		m.notifier.notify(Event{
+			Type:  EventAttack,
+			Count: int(count),
+		})
+	}
+}
+
+func (m *BlockIPManager) Remove(ip string) error {
+	key := fmt.Sprintf(redisBlock, ip)
+	countKey := fmt.Sprintf(redisBlockCount, ip)
+
+	count, err := m.Store.Del(m.Context, key, countKey)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to remove block item from redis")
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: %s is not blocked", ErrNotFound, ip)
+	}
+
 	return nil
 }
+
+func (m *BlockIPManager) Get(ip string) (*IPItem, error) {
+	isBlock, item, err := m.checkBlockIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBlock {
+		return nil, nil
+	}
+
+	return item, nil
+}
+
+// * remaining block time, 0 when not blocked
+func (m *BlockIPManager) TTL(ip string) (time.Duration, error) {
+	key := fmt.Sprintf(redisBlock, ip)
+
+	ttl, err := m.Store.TTL(m.Context, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// * -1 no expiry, -2 not found
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// * page starts from 1, return items of the page and total count
+func (m *BlockIPManager) List(page, size int) ([]IPItem, int, error) {
+	found, err := m.Store.Scan(m.Context, fmt.Sprintf(redisBlock, "*"))
+	if err != nil {
+		return nil, 0, m.Logger.Error(err, "Failed to scan block items")
+	}
+
+	var keys []string
+	prefix := fmt.Sprintf(redisBlockCount, "")
+	for _, key := range found {
+		// * skip block:count:* keys
+		if strings.HasPrefix(key, prefix) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return []IPItem{}, 0, nil
+	}
+
+	values, err := m.Store.MGet(m.Context, keys...)
+	if err != nil {
+		return nil, 0, m.Logger.Error(err, "Failed to get block items")
+	}
+
+	list := make([]IPItem, 0, len(values))
+	for _, value := range values {
+		// * expired between scan and get
+		if value == "" {
+			continue
+		}
+
+		var item IPItem
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			continue
+		}
+		list = append(list, item)
+	}
+
+	sortIPItems(list)
+
+	return paginateIPItems(list, page, size), len(list), nil
+}
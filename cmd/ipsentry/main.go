@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+)
+
+const usage = `Usage: ipsentry [-config config.json] <command> [arguments]
+
+Commands:
+  allow   <ip> [reason]        add IP, CIDR or range to white list
+  deny    <ip> [reason]        add IP, CIDR or range to black list
+  block   <ip> [reason]        block IP
+  unallow <ip>                 remove entry from white list
+  undeny  <ip>                 remove entry from black list
+  unblock <ip>                 remove IP from block list
+  show    <ip>                 show counters, matched entries and block history
+  import  <allow|deny> <file>  import list file
+  export  <allow|deny> [file]  export list file, default to stdout
+  top     [n]                  show top n blocked IPs, default 10
+
+List changes:
+  block and unblock are stored in Redis and apply to running servers at once.
+  allow, deny, unallow, undeny and import also write the list file set in
+  filepath of the config; running servers watching the same file apply CIDR
+  and range entries on their next reload (filepath.reload_interval, default
+  10s). Use the config of the servers, or a copy with the same list paths.
+`
+
+// * upper bound for sending the notifications of a command, retries included
+const notifyFlushTimeout = 30 * time.Second
+
+func main() {
+	configPath := flag.String("config", "config.json", "path of Config JSON or YAML")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*configPath, flag.Args(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ipsentry:", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, args []string, stdout io.Writer) error {
+	config, err := golangIPSentry.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	guardian, err := golangIPSentry.New(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// * ban and block notifications are queued, Close would drop them
+		if err := guardian.Flush(notifyFlushTimeout); err != nil {
+			fmt.Fprintln(os.Stderr, "ipsentry:", err)
+		}
+		guardian.Close()
+	}()
+
+	command, args := args[0], args[1:]
+	manager := guardian.Manager
+
+	switch command {
+	case "allow", "deny", "block":
+		if len(args) < 1 {
+			return fmt.Errorf("%s requires an IP", command)
+		}
+		reason := strings.Join(args[1:], " ")
+		path := ""
+		switch command {
+		case "allow":
+			err = manager.Allow.Add(args[0], reason)
+			path = manager.Allow.Path()
+		case "deny":
+			err = manager.Deny.Add(args[0], reason)
+			path = manager.Deny.Path()
+		case "block":
+			// * block entries are single IPs
+			if _, err := netip.ParseAddr(args[0]); err != nil {
+				return fmt.Errorf("invalid IP: %s", args[0])
+			}
+			err = manager.Block.Add(args[0], reason)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", command, args[0])
+		printSaved(stdout, path)
+		return nil
+
+	case "unallow", "undeny", "unblock":
+		if len(args) != 1 {
+			return fmt.Errorf("%s requires an IP", command)
+		}
+		path := ""
+		switch command {
+		case "unallow":
+			err = manager.Allow.Remove(args[0])
+			path = manager.Allow.Path()
+		case "undeny":
+			err = manager.Deny.Remove(args[0])
+			path = manager.Deny.Path()
+		case "unblock":
+			err = manager.Block.Remove(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", command, args[0])
+		printSaved(stdout, path)
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("show requires an IP")
+		}
+		return show(guardian, args[0], stdout)
+
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("import requires a list name and a file")
+		}
+		return importList(guardian, args[0], args[1], stdout)
+
+	case "export":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("export requires a list name")
+		}
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+		}
+		return exportList(guardian, args[0], path, stdout)
+
+	case "top":
+		limit := 10
+		if len(args) > 0 {
+			if limit, err = strconv.Atoi(args[0]); err != nil || limit <= 0 {
+				return fmt.Errorf("invalid count: %s", args[0])
+			}
+		}
+		return top(guardian, limit, stdout)
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+}
+
+func show(guardian *golangIPSentry.IPGuardian, ip string, stdout io.Writer) error {
+	state, err := guardian.Inspect(ip)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(data))
+
+	// * block reasons are appended on each escalation
+	if state.Block != nil {
+		fmt.Fprintf(stdout, "\nBlock history (%d times):\n", state.Block.Count)
+		for _, reason := range strings.Split(state.Block.Reason, "\n") {
+			fmt.Fprintf(stdout, "  - %s\n", reason)
+		}
+	}
+
+	return nil
+}
+
+func readList(guardian *golangIPSentry.IPGuardian, name string) ([]golangIPSentry.IPItem, error) {
+	var list func(page, size int) ([]golangIPSentry.IPItem, int, error)
+	switch name {
+	case "allow":
+		list = guardian.Manager.Allow.List
+	case "deny":
+		list = guardian.Manager.Deny.List
+	case "block":
+		list = guardian.Manager.Block.List
+	default:
+		return nil, fmt.Errorf("unknown list %q", name)
+	}
+
+	_, total, err := list(1, 1)
+	if err != nil || total == 0 {
+		return []golangIPSentry.IPItem{}, err
+	}
+
+	items, _, err := list(1, total)
+	return items, err
+}
+
+func importList(guardian *golangIPSentry.IPGuardian, name, path string, stdout io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var list []golangIPSentry.IPItem
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var count int
+	var saved string
+	switch name {
+	case "allow":
+		count, err = guardian.Manager.Allow.Import(list)
+		saved = guardian.Manager.Allow.Path()
+	case "deny":
+		count, err = guardian.Manager.Deny.Import(list)
+		saved = guardian.Manager.Deny.Path()
+	default:
+		return fmt.Errorf("unknown list %q", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "imported %d of %d entries to %s list\n", count, len(list), name)
+	printSaved(stdout, saved)
+	return nil
+}
+
+// * servers match CIDR and range entries after reloading the list file, see usage
+func printSaved(stdout io.Writer, path string) {
+	if path == "" {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	fmt.Fprintf(stdout, "saved to %s, servers watching it apply the change on their next reload\n", path)
+}
+
+func exportList(guardian *golangIPSentry.IPGuardian, name, path string, stdout io.Writer) error {
+	if name != "allow" && name != "deny" {
+		return fmt.Errorf("unknown list %q", name)
+	}
+
+	list, err := readList(guardian, name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		fmt.Fprintln(stdout, string(data))
+		return nil
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func top(guardian *golangIPSentry.IPGuardian, limit int, stdout io.Writer) error {
+	list, err := readList(guardian, "block")
+	if err != nil {
+		return err
+	}
+
+	// * most escalated first, then most recent
+	sort.SliceStable(list, func(a, b int) bool {
+		if list[a].Count != list[b].Count {
+			return list[a].Count > list[b].Count
+		}
+		return list[a].Last > list[b].Last
+	})
+
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "IP\tCOUNT\tTTL\tLAST\tREASON")
+	for _, item := range list {
+		// * 0 when the block has no expiration
+		ttl := "-"
+		if remaining, err := guardian.Manager.Block.TTL(item.IP); err == nil && remaining > 0 {
+			ttl = remaining.Round(time.Second).String()
+		}
+
+		reasons := strings.Split(item.Reason, "\n")
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\n",
+			item.IP,
+			item.Count,
+			ttl,
+			time.Unix(item.Last, 0).UTC().Format(time.RFC3339),
+			reasons[len(reasons)-1],
+		)
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,446 @@
+package golangIPSentry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "IPSENTRY_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var rateLimitType = reflect.TypeOf(RateLimit{})
+
+// * JSON or YAML by extension, durations accept "15m", IPSENTRY_* variables override the file
+// * e.g. IPSENTRY_REDIS_HOST, IPSENTRY_PARAMETER_BLOCK_TIME_MIN=15m, IPSENTRY_PARAMETER_HIGH_RISK_COUNTRY=CN,RU
+// * empty path reads the environment only
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	tree := map[string]any{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &tree)
+		default:
+			err = json.Unmarshal(data, &tree)
+		}
+		if err != nil {
+			return config, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if tree == nil {
+			tree = map[string]any{}
+		}
+	}
+
+	var errs []error
+	errs = append(errs, applyEnv(tree, os.Environ())...)
+
+	normalized, durationErrs := normalizeConfig(reflect.TypeOf(config), tree, "")
+	errs = append(errs, durationErrs...)
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return config, errors.Join(append(errs, err)...)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		// * the config is incomplete, not validated
+		return config, errors.Join(append(errs, fmt.Errorf("failed to decode config: %w", err))...)
+	}
+
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return config, errors.Join(errs...)
+}
+
+// * JSON fields over base, durations accept "15m" as in LoadConfig
+func decodeParameter(data []byte, base Parameter) (Parameter, error) {
+	var tree any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return base, err
+	}
+
+	normalized, errs := normalizeConfig(reflect.TypeOf(base), tree, "parameter")
+	if len(errs) > 0 {
+		return base, errors.Join(errs...)
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return base, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&base); err != nil {
+		return base, err
+	}
+
+	return base, nil
+}
+
+// * every invalid value is reported
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch c.Mode {
+	case "", ModeEnforce, ModeMonitor:
+	default:
+		add("mode: unknown mode %q", c.Mode)
+	}
+
+	switch c.Failure.Mode {
+	case "", FailOpen, FailClosed, FailLocal:
+	default:
+		add("failure.mode: unknown mode %q", c.Failure.Mode)
+	}
+	if c.Failure.Timeout < 0 || c.Failure.Threshold < 0 || c.Failure.Cooldown < 0 {
+		add("failure: must not be negative")
+	}
+	if t := c.ScorerTimeout; t.Basic < 0 || t.Geo < 0 || t.Behavior < 0 || t.Fingerprint < 0 {
+		add("scorer_timeout: must not be negative")
+	}
+	if c.AbuseIPDBTimeout < 0 {
+		add("abuseipdb_timeout: must not be negative")
+	}
+
+	if c.Redis.Port < 0 || c.Redis.Port > 65535 {
+		add("redis.port: %d is out of range", c.Redis.Port)
+	}
+
+	if err := validateParameter(c.Parameter); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		add("trusted_proxies: %w", err)
+	}
+	if _, err := compileRoutes(c.Routes); err != nil {
+		add("routes: %w", err)
+	}
+
+	if c.Email != nil {
+		switch c.Email.TLS {
+		case "", EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+		default:
+			add("email.tls: unknown TLS mode %q", c.Email.TLS)
+		}
+	}
+	for idx, notifier := range c.Notifiers {
+		switch notifier.Type {
+		case "webhook", "slack", "discord":
+		default:
+			add("notifiers[%d].type: unknown notifier type %q", idx, notifier.Type)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// * checks of Validate and UpdatePolicy
+func validateParameter(p Parameter) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for _, country := range p.HighRiskCountry {
+		if !isCountryCode(country) {
+			add("parameter.high_risk_country: %q is not an ISO 3166-1 alpha-2 code", country)
+		}
+	}
+
+	disableable := make(map[string]bool)
+	for _, item := range parameterDefaults(&p) {
+		disableable[item.name] = true
+	}
+
+	value := reflect.ValueOf(p)
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Field(idx)
+		if field.Kind() == reflect.Int || field.Kind() == reflect.Int64 {
+			name := jsonName(value.Type().Field(idx))
+			switch {
+			case field.Int() == Disabled && disableable[name]:
+			case field.Int() == Disabled:
+				add("parameter.%s: Disabled is only supported by thresholds and scores, 0 uses the default", name)
+			case field.Int() < 0:
+				add("parameter.%s: must not be negative", name)
+			}
+		}
+	}
+
+	switch p.RateLimitAlgorithm {
+	case "", RateLimitSlidingLog, RateLimitSlidingCounter, RateLimitGCRA:
+	default:
+		add("parameter.rate_limit_algorithm: unknown algorithm %q", p.RateLimitAlgorithm)
+	}
+	for name, limit := range map[string]RateLimit{
+		"rate_limit_normal":     p.RateLimitNormal,
+		"rate_limit_suspicious": p.RateLimitSuspicious,
+		"rate_limit_dangerous":  p.RateLimitDangerous,
+	} {
+		switch {
+		case limit.Limit == Disabled:
+			add("parameter.%s: Disabled is not supported by rate limit tiers, use a route with skip to bypass them", name)
+		case limit.Limit < 0 || limit.Burst < 0 || limit.Window < 0:
+			add("parameter.%s: must not be negative", name)
+		}
+	}
+
+	// * defaults apply to unset values, e.g. 90 with the default score_dangerous 80
+	if resolved, err := resolvePolicy(p); err == nil {
+		suspicious, dangerous := resolved.ScoreSuspicious, resolved.ScoreDangerous
+		if suspicious != Disabled && dangerous != Disabled && suspicious > dangerous {
+			add("parameter.score_suspicious: %d is greater than score_dangerous %d", suspicious, dangerous)
+		}
+	}
+	if p.BlockTimeMin > 0 && p.BlockTimeMax > 0 && p.BlockTimeMin > p.BlockTimeMax {
+		add("parameter.block_time_min: %s is greater than block_time_max %s", p.BlockTimeMin, p.BlockTimeMax)
+	}
+
+	return errors.Join(errs...)
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, char := range code {
+		if char < 'A' || char > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// * IPSENTRY_ + upper case JSON path of every scalar and string list field
+func envFields(t reflect.Type, prefix []string, fields map[string][]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		name := jsonName(field)
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		path := append(append([]string{}, prefix...), name)
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			envFields(fieldType, path, fields)
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() != reflect.String:
+			// * lists of objects, e.g. routes, are file only
+		default:
+			fields[envPrefix+strings.ToUpper(strings.Join(path, "_"))] = path
+		}
+	}
+}
+
+func applyEnv(tree map[string]any, environ []string) []error {
+	fields := make(map[string][]string)
+	envFields(reflect.TypeOf(Config{}), nil, fields)
+
+	var errs []error
+environ:
+	for _, pair := range environ {
+		key, value, _ := strings.Cut(pair, "=")
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		path, ok := fields[key]
+		if !ok {
+			continue
+		}
+
+		field, _ := fieldByPath(reflect.TypeOf(Config{}), path)
+		parsed, err := parseEnvValue(field, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+
+		node := tree
+		for idx, name := range path[:len(path)-1] {
+			name := treeKey(node, name)
+			child, ok := node[name].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				if value := node[name]; value != nil {
+					// * "rate_limit_normal": 100 keeps its limit when a sub-field is overridden
+					if parent, _ := fieldByPath(reflect.TypeOf(Config{}), path[:idx+1]); parent != rateLimitType {
+						errs = append(errs, fmt.Errorf("%s: %s is not an object in the config file", key, strings.Join(path[:idx+1], ".")))
+						continue environ
+					}
+					child["limit"] = value
+				}
+				node[name] = child
+			}
+			node = child
+		}
+		node[treeKey(node, path[len(path)-1])] = parsed
+	}
+
+	return errs
+}
+
+func fieldByPath(t reflect.Type, path []string) (reflect.Type, bool) {
+	for _, name := range path {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		found := false
+		for idx := 0; idx < t.NumField(); idx++ {
+			if jsonName(t.Field(idx)) == name {
+				t = t.Field(idx).Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t, true
+}
+
+func parseEnvValue(t reflect.Type, value string) (any, error) {
+	if t == durationType {
+		// * nanoseconds or duration string, normalized later
+		if ns, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return ns, nil
+		}
+		return value, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Slice:
+		list := []any{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// * existing key of the node, JSON field names are case insensitive
+func treeKey(node map[string]any, name string) string {
+	for key := range node {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// * duration strings to nanoseconds, following the JSON field names of t
+func normalizeConfig(t reflect.Type, value any, path string) (any, []error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		str, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			// * dropped so the remaining fields are still decoded and validated
+			return nil, []error{fmt.Errorf("%s: invalid duration %q", path, str)}
+		}
+		return int64(duration), nil
+	}
+
+	var errs []error
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		node, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			name := jsonName(field)
+			if name == "" || name == "-" {
+				continue
+			}
+			key := treeKey(node, name)
+			child, exist := node[key]
+			if !exist {
+				continue
+			}
+			normalized, childErrs := normalizeConfig(field.Type, child, join(name))
+			node[key] = normalized
+			errs = append(errs, childErrs...)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]any)
+		if !ok {
+			return value, nil
+		}
+		for idx, child := range list {
+			normalized, childErrs := normalizeConfig(t.Elem(), child, fmt.Sprintf("%s[%d]", path, idx))
+			list[idx] = normalized
+			errs = append(errs, childErrs...)
+		}
+	}
+
+	return value, errs
+}
@@ -4,31 +4,33 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/smtp"
+	"net/netip"
 	"os"
-	"strings"
 	"sync"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
 type DenyIPManager struct {
-	Logger  *Logger
-	Config  *Config
-	Redis   *redis.Client
-	Context context.Context
-	Mutex   sync.RWMutex
-	Cache   map[string]*IPItem
+	Logger   *Logger
+	Config   *Config
+	Store    Store
+	Context  context.Context
+	Mutex    sync.RWMutex
+	Cache    map[string]*IPItem
+	trie     *ipTrie
+	file     listFile
+	notifier *notifyDispatcher
 }
 
 func (i *IPGuardian) newDenyIPManager() *DenyIPManager {
 	manager := &DenyIPManager{
-		Logger:  i.Logger,
-		Config:  i.Config,
-		Redis:   i.Redis,
-		Context: i.Context,
-		Cache:   make(map[string]*IPItem),
+		Logger:   i.Logger,
+		Config:   i.Config,
+		Store:    i.Store,
+		Context:  i.Context,
+		Cache:    make(map[string]*IPItem),
+		trie:     newIPTrie(),
+		notifier: i.notifier,
 	}
 
 	err := manager.load()
@@ -43,22 +45,23 @@ func (m *DenyIPManager) load() error {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
-	path := defaultBlackListPath
-	// * custom path is exist, use it
-	if m.Config.Filepath.BlackList != "" {
-		path = m.Config.Filepath.BlackList
-	}
+	path := m.Path()
 
 	// * file is not exist, skip importing
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	data, err := os.ReadFile(path)
 	// * failed to read file, stop importing
 	if err != nil {
 		return err
 	}
+	m.file = newListFile(info, data)
 
 	var list []IPItem
 	// * failed to parse json, stop importing
@@ -66,102 +69,209 @@ func (m *DenyIPManager) load() error {
 		return err
 	}
 
-	pipe := m.Redis.Pipeline()
+	_, err = m.insert(list)
+	return err
+}
+
+// * caller must hold the mutex, return inserted count
+func (m *DenyIPManager) insert(list []IPItem) (int, error) {
+	pipe := m.Store.Pipeline()
+	items := make([]*IPItem, 0, len(list))
+	entries := make([][]netip.Prefix, 0, len(list))
 
 	for _, item := range list {
+		entry, prefixes, err := parseIPEntry(item.IP)
+		// * invalid IP, CIDR or range, skip this item
+		if err != nil {
+			m.Logger.WarnError(err, "Skip invalid black list entry")
+			continue
+		}
+		item.IP = entry
+
 		data, err := json.Marshal(item)
 		// * failed to parse item, skip this item
 		if err != nil {
 			continue
 		}
-		// * add item to memory cache
-		m.Cache[item.IP] = &item
 
 		key := fmt.Sprintf(redisDeny, item.IP)
 		pipe.Set(m.Context, key, data, 0)
+		items = append(items, &item)
+		entries = append(entries, prefixes)
 	}
 
-	_, err = pipe.Exec(m.Context)
+	err := pipe.Exec(m.Context)
 	// * failed to execute pipeline, stop importing
 	if err != nil {
-		return m.Logger.Error(err, "Failed to store black list to redis")
+		return 0, m.Logger.Error(err, "Failed to store black list to redis")
 	}
 
-	return nil
+	// * add items to memory cache once stored, a failed write is not matched
+	for idx, item := range items {
+		m.Cache[item.IP] = item
+		for _, prefix := range entries[idx] {
+			m.trie.insert(prefix, item)
+		}
+	}
+
+	return len(items), nil
+}
+
+// * bulk add in list file format, no notification is sent
+func (m *DenyIPManager) Import(list []IPItem) (int, error) {
+	now := time.Now().UTC().Unix()
+	items := make([]IPItem, len(list))
+	for idx, item := range list {
+		if item.AddedAt == 0 {
+			item.AddedAt = now
+		}
+		items[idx] = item
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	count, err := m.insert(items)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.save(); err != nil {
+		return count, m.Logger.Error(err, "Failed to save black list to file")
+	}
+
+	return count, nil
 }
 
 func (m *DenyIPManager) Check(ip string) bool {
+	item, _ := m.match(ip)
+	return item != nil
+}
+
+// * entry in the Store first, then the entries in memory covering the IP
+// * error of the Store when nothing matched, entries in memory still match without it
+func (m *DenyIPManager) match(ip string) (*IPItem, error) {
 	key := fmt.Sprintf(redisDeny, ip)
-	exist, err := m.Redis.Exists(m.Context, key).Result()
-	if err == nil && exist > 0 {
-		return true
+	data, storeErr := m.Store.Get(m.Context, key)
+	if storeErr == nil {
+		var item IPItem
+		if err := json.Unmarshal([]byte(data), &item); err != nil || item.IP == "" {
+			// * still a match, only the detail is unknown
+			item = IPItem{IP: ip}
+		}
+		return &item, nil
+	}
+	if storeErr == ErrNil {
+		storeErr = nil
 	}
 
 	m.Mutex.RLock()
 	defer m.Mutex.RUnlock()
 
-	_, cache := m.Cache[ip]
+	// * match single IP, CIDR and range entries
+	item, ok := m.Cache[ip]
+	if !ok {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			item = m.trie.lookup(addr)
+		}
+	}
+	if item == nil {
+		return nil, storeErr
+	}
 
-	return cache
+	copied := *item
+	return &copied, nil
 }
 
-// * save black list to file
-func (m *DenyIPManager) save() error {
-	path := defaultBlackListPath
+// * list file read, written and watched by the manager
+func (m *DenyIPManager) Path() string {
 	if m.Config.Filepath.BlackList != "" {
-		path = m.Config.Filepath.BlackList
+		return m.Config.Filepath.BlackList
 	}
+	return defaultBlackListPath
+}
 
-	var list []IPItem
+// * save black list to file
+func (m *DenyIPManager) save() error {
+	path := m.Path()
+
+	list := make([]IPItem, 0, len(m.Cache))
 	for _, item := range m.Cache {
 		list = append(list, *item)
 	}
+	sortIPItems(list)
 
 	data, err := json.MarshalIndent(list, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	// * own writes are not reloaded
+	if info, err := os.Stat(path); err == nil {
+		m.file = newListFile(info, data)
+	}
+	return nil
 }
 
-func (m *DenyIPManager) sendEmail(ip string, reason string) {
-	if m.Config.Email == nil {
+// * apply changes of the list file, the current list is kept when the file is invalid
+func (m *DenyIPManager) reload() {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	path := m.Path()
+
+	list, changed, err := m.file.read(path)
+	if err != nil {
+		m.Logger.Error(err, "Failed to reload black list, keep the last version")
+		return
+	}
+	if !changed {
 		return
 	}
 
-	subject := fmt.Sprintf("[IP Sentry] IP %s has been banned", ip)
-	if m.Config.Email.Subject != nil {
-		str := (*m.Config.Email.Subject)(ip, reason)
-		if str != "" {
-			subject = str
-		}
+	cache, diff := diffIPItems(m.Cache, list)
+	if diff.empty() {
+		return
 	}
-	body := fmt.Sprintf("[IP Sentry] IP %s has been banned for %s", ip, reason)
-	if m.Config.Email.Body != nil {
-		str := (*m.Config.Email.Body)(ip, reason)
-		if str != "" {
-			body = str
+
+	pipe := m.Store.Pipeline()
+	for _, entries := range [][]string{diff.Added, diff.Changed} {
+		for _, entry := range entries {
+			data, err := json.Marshal(cache[entry])
+			if err != nil {
+				continue
+			}
+			pipe.Set(m.Context, fmt.Sprintf(redisDeny, entry), data, 0)
 		}
 	}
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nCc: %s\r\nSubject: %s\r\n\r\n%s",
-		m.Config.Email.From,
-		strings.Join(m.Config.Email.To, ","),
-		strings.Join(m.Config.Email.CC, ","),
-		subject,
-		body)
+	for _, entry := range diff.Removed {
+		pipe.Del(m.Context, fmt.Sprintf(redisDeny, entry))
+	}
+	if err := pipe.Exec(m.Context); err != nil {
+		// * retry on the next poll
+		m.file = listFile{}
+		m.Logger.Error(err, "Failed to store black list to redis, keep the last version")
+		return
+	}
 
-	auth := smtp.PlainAuth("", m.Config.Email.Username, m.Config.Email.Password, m.Config.Email.Host)
-	addr := fmt.Sprintf("%s:%d", m.Config.Email.Host, m.Config.Email.Port)
+	m.Cache = cache
+	m.rebuild()
 
-	err := smtp.SendMail(addr, auth, m.Config.Email.From, m.Config.Email.To, []byte(msg))
-	if err != nil {
-		m.Logger.Error(err, "Failed to send email")
-	}
+	m.Logger.Info(diff.messages("Black list reloaded")...)
 }
 
 // * public
 func (m *DenyIPManager) Add(ip, reason string) error {
+	entry, prefixes, err := parseIPEntry(ip)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to parse black ip")
+	}
+	ip = entry
+
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
@@ -171,25 +281,104 @@ func (m *DenyIPManager) Add(ip, reason string) error {
 		AddedAt: time.Now().UTC().Unix(),
 	}
 
-	m.Cache[ip] = item
-
 	data, err := json.Marshal(item)
 	if err != nil {
 		return m.Logger.Error(err, "Failed to parse black ip")
 	}
 
 	key := fmt.Sprintf(redisDeny, ip)
-	if err := m.Redis.Set(m.Context, key, data, 0).Err(); err != nil {
+	if err := m.Store.Set(m.Context, key, data, 0); err != nil {
 		return m.Logger.Error(err, "Failed to store black ip to redis")
 	}
 
+	// * matched only once stored
+	m.Cache[ip] = item
+	for _, prefix := range prefixes {
+		m.trie.insert(prefix, item)
+	}
+
 	if err := m.save(); err != nil {
 		return m.Logger.Error(err, "Failed to save black ip to file")
 	}
 
-	if m.Config != nil {
-		go m.sendEmail(ip, reason)
+	m.notifier.notify(Event{
+		Type:   EventBan,
+		IP:     ip,
+		Reason: reason,
+	})
+
+	return nil
+}
+
+func (m *DenyIPManager) Remove(ip string) error {
+	entry, prefixes, err := parseIPEntry(ip)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to parse black ip")
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	key := fmt.Sprintf(redisDeny, entry)
+	count, err := m.Store.Del(m.Context, key)
+	if err != nil {
+		return m.Logger.Error(err, "Failed to remove black ip from redis")
+	}
+
+	if _, ok := m.Cache[entry]; !ok {
+		if count == 0 {
+			return fmt.Errorf("%w: %s is not in black list", ErrNotFound, entry)
+		}
+		return nil
+	}
+
+	delete(m.Cache, entry)
+	for _, prefix := range prefixes {
+		m.trie.remove(prefix, entry)
+	}
+
+	if err := m.save(); err != nil {
+		return m.Logger.Error(err, "Failed to save black list to file")
 	}
 
 	return nil
 }
+
+// * same lookup as Check, nil when the IP does not match
+// * a CIDR or range returns its own entry
+func (m *DenyIPManager) Get(ip string) (*IPItem, error) {
+	entry, _, err := parseIPEntry(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.match(entry)
+}
+
+// * page starts from 1, return items of the page and total count
+func (m *DenyIPManager) List(page, size int) ([]IPItem, int, error) {
+	m.Mutex.RLock()
+	list := make([]IPItem, 0, len(m.Cache))
+	for _, item := range m.Cache {
+		list = append(list, *item)
+	}
+	m.Mutex.RUnlock()
+
+	sortIPItems(list)
+
+	return paginateIPItems(list, page, size), len(list), nil
+}
+
+func (m *DenyIPManager) rebuild() {
+	trie := newIPTrie()
+	for entry, item := range m.Cache {
+		_, prefixes, err := parseIPEntry(entry)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range prefixes {
+			trie.insert(prefix, item)
+		}
+	}
+	m.trie = trie
+}
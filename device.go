@@ -7,10 +7,9 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"math"
 	"net"
-	"net/http"
 	"os"
 	"regexp"
 	"strings"
@@ -28,6 +27,8 @@ type Device struct {
 	Referer     string
 	SessionID   string
 	Fingerprint string
+	storeErr    error   // * list lookups failed, see Config.Failure
+	match       *IPItem // * allow or deny entry found by the list lookups
 }
 
 type IS struct {
@@ -48,9 +49,9 @@ type IP struct {
 	BlockCount   int
 }
 
-func (i *IPGuardian) getDevice(w http.ResponseWriter, r *http.Request) (*Device, error) {
-	userAgent := r.UserAgent()
-	ipAddress, isPrivate, err := getClientIP(r)
+func (i *IPGuardian) getDevice(r Request) (*Device, error) {
+	userAgent := r.Header("User-Agent")
+	ipAddress, isPrivate, err := i.getClientIP(r)
 
 	if err != nil {
 		return nil, i.Logger.Error(nil, "Failed to get client IP")
@@ -72,39 +73,25 @@ func (i *IPGuardian) getDevice(w http.ResponseWriter, r *http.Request) (*Device,
 			Tablet:   deviceType == "Tablet",
 			Desktop:  deviceType == "Desktop",
 			Internal: isPrivate,
-			Trust:    i.Manager.Allow.Check(ipAddress),
-			Ban:      i.Manager.Deny.Check(ipAddress),
-			Block:    i.Manager.Block.IsBlock(ipAddress),
 		},
 		OS: getOS(userAgent),
 		IP: IP{
 			Address: ipAddress,
 			Level:   ipTrustLevel,
 		},
-		AcceptLang: r.Header.Get("Accept-Language"),
-		Referer:    r.Header.Get("Referer"),
+		AcceptLang: r.Header("Accept-Language"),
+		Referer:    r.Header("Referer"),
 	}
 
-	requestCount, err := i.requestCountInMin(ipAddress)
-	if err != nil {
-		requestCount = 1
-	}
-
-	deviceInfo.IP.RequestCount = requestCount
-
-	blockCount, err := i.blockCountInHour(ipAddress)
-	if err != nil {
-		blockCount = 0
-	}
-	deviceInfo.IP.BlockCount = blockCount
+	deviceInfo.storeErr = i.lookupLists(deviceInfo)
 
-	sessionID, err := getSessionID(w, r, deviceInfo)
+	sessionID, err := getSessionID(r, deviceInfo)
 	if err != nil {
 		return nil, err
 	}
 	deviceInfo.SessionID = sessionID
 
-	fingerprint, err := getFingerprint(w, r, deviceInfo)
+	fingerprint, err := getFingerprint(r, deviceInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -242,21 +229,18 @@ func parseSessionID(signed string) (string, bool) {
 	return sessionID, true
 }
 
-func getSessionID(w http.ResponseWriter, r *http.Request, d *Device) (string, error) {
-	cookie, err := r.Cookie(sessionKey)
-	if err == nil && cookie.Value != "" {
-		sessionID, valid := parseSessionID(cookie.Value)
+func getSessionID(r Request, d *Device) (string, error) {
+	if value := r.Cookie(sessionKey); value != "" {
+		sessionID, valid := parseSessionID(value)
 		if valid {
-			newCookie := &http.Cookie{
+			r.SetCookie(Cookie{
 				Name:     sessionKey,
-				Value:    cookie.Value,
+				Value:    value,
 				Path:     "/",
 				MaxAge:   30 * 24 * 3600,
-				HttpOnly: true,
 				Secure:   true,
-				SameSite: http.SameSiteStrictMode,
-			}
-			http.SetCookie(w, newCookie)
+				SameSite: "Strict",
+			})
 			return sessionID, nil
 		}
 	}
@@ -266,53 +250,38 @@ func getSessionID(w http.ResponseWriter, r *http.Request, d *Device) (string, er
 		return "", err
 	}
 
-	cookie = &http.Cookie{
+	r.SetCookie(Cookie{
 		Name:     sessionKey,
 		Value:    signedSessionID,
 		Path:     "/",
 		MaxAge:   30 * 24 * 3600,
-		HttpOnly: true,
 		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-	http.SetCookie(w, cookie)
+		SameSite: "Strict",
+	})
 
 	sessionID, _ := parseSessionID(signedSessionID)
 	return sessionID, nil
 }
 
-func getFingerprint(w http.ResponseWriter, r *http.Request, d *Device) (string, error) {
-	key, err := uuid(128)
-	if err != nil {
-		return "", err
-	}
-
-	cookie, err := r.Cookie(deviceKey)
-	if err == nil && cookie.Value != "" {
-		key = cookie.Value
-		newCookie := &http.Cookie{
-			Name:     deviceKey,
-			Value:    key,
-			Path:     "/",
-			MaxAge:   365 * 86400,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-		}
-		http.SetCookie(w, newCookie)
-	} else {
-		cookie = &http.Cookie{
-			Name:     deviceKey,
-			Value:    key,
-			Path:     "/",
-			MaxAge:   365 * 86400,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
+func getFingerprint(r Request, d *Device) (string, error) {
+	key := r.Cookie(deviceKey)
+	if key == "" {
+		var err error
+		key, err = uuid(128)
+		if err != nil {
+			return "", err
 		}
-		http.SetCookie(w, cookie)
 	}
 
+	r.SetCookie(Cookie{
+		Name:     deviceKey,
+		Value:    key,
+		Path:     "/",
+		MaxAge:   365 * 86400,
+		Secure:   true,
+		SameSite: "Strict",
+	})
+
 	info := fmt.Sprintf("%s/%s/%s/%s/%s",
 		d.Platform,
 		d.Browser,
@@ -342,62 +311,6 @@ func uuid(length int) (string, error) {
 	return string(bytes), nil
 }
 
-func getClientIP(r *http.Request) (string, bool, error) {
-	headers := []string{
-		"CF-Connecting-IP",    // Cloudflare
-		"X-Forwarded-For",     // 標準反向代理
-		"X-Real-IP",           // Nginx
-		"X-Client-IP",         // Apache
-		"X-Cluster-Client-IP", // 叢集
-		"X-Forwarded",         // 舊版本
-		"Forwarded-For",       // 舊版本
-		"Forwarded",           // 新標準
-	}
-
-	for _, header := range headers {
-		if ip := r.Header.Get(header); ip != "" {
-			if strings.Contains(ip, ",") {
-				ip = strings.TrimSpace(strings.Split(ip, ",")[0])
-			}
-			if parsedIP := net.ParseIP(ip); parsedIP != nil {
-				return ip, isInternalIP(r), nil
-			}
-		}
-	}
-
-	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		if parsedIP := net.ParseIP(ip); parsedIP != nil {
-			return ip, isInternalIP(r), nil
-		}
-	}
-
-	if parsedIP := net.ParseIP(r.RemoteAddr); parsedIP != nil {
-		return r.RemoteAddr, isInternalIP(r), nil
-	}
-
-	return "", false, fmt.Errorf("failed to parse client IP")
-}
-
-func getRemoteIP(r *http.Request) string {
-	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		return ip
-	}
-	return r.RemoteAddr
-}
-
-func isInternalIP(r *http.Request) bool {
-	directIP := getRemoteIP(r)
-	if directIP != "" && isInternal(directIP) {
-		return true
-	}
-
-	if hasProxyHeader(r) {
-		return checkProxy(r)
-	}
-
-	return false
-}
-
 func isInternal(ip string) bool {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
@@ -421,65 +334,6 @@ func isInternalIPRange(ip net.IP) bool {
 	return false
 }
 
-func hasProxyHeader(r *http.Request) bool {
-	proxyHeaders := []string{
-		"X-Forwarded-For",
-		"X-Real-IP",
-		"CF-Connecting-IP",
-		"X-Forwarded-Proto",
-	}
-
-	for _, header := range proxyHeaders {
-		if r.Header.Get(header) != "" {
-			return true
-		}
-	}
-	return false
-}
-
-func checkProxy(r *http.Request) bool {
-	directIP := getRemoteIP(r)
-	if !isInternal(directIP) {
-		return false
-	}
-
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return checkXFF(xff, directIP)
-	}
-
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return isInternal(realIP)
-	}
-
-	return false
-}
-
-func checkXFF(xff, proxyIP string) bool {
-	ips := strings.Split(xff, ",")
-	if len(ips) == 0 {
-		return false
-	}
-
-	clientIP := strings.TrimSpace(ips[0])
-	if !isInternal(clientIP) {
-		return false
-	}
-
-	for idx := 1; idx < len(ips); idx++ {
-		proxyInChain := strings.TrimSpace(ips[idx])
-		if !isInternal(proxyInChain) {
-			return false
-		}
-	}
-
-	if len(ips) > 1 {
-		lastProxy := strings.TrimSpace(ips[len(ips)-1])
-		return lastProxy == proxyIP
-	}
-
-	return true
-}
-
 func getPlatform(userAgent string) string {
 	ua := strings.ToLower(userAgent)
 
@@ -565,35 +419,51 @@ func getOS(userAgent string) string {
 	return getPlatform(userAgent)
 }
 
-func (i *IPGuardian) requestCountInMin(ip string) (int, error) {
-	key := fmt.Sprintf(redisFrequency, ip, int64(math.Floor(float64(time.Now().UTC().Unix())/60)))
+// * in the order check uses them, a match skips the later lookups
+func (i *IPGuardian) lookupLists(device *Device) error {
+	var errs []error
+	ip := device.IP.Address
 
-	count, err := i.Redis.Incr(i.Context, key).Result() // * 自動計數
-	if err != nil {
-		return 1, err
+	trust, err := i.Manager.Allow.match(ip)
+	if trust != nil {
+		device.Is.Trust = true
+		device.match = trust
+		return nil
 	}
+	errs = append(errs, err)
 
-	if count == 1 {
-		i.Redis.Expire(i.Context, key, 2*time.Minute)
+	ban, err := i.Manager.Deny.match(ip)
+	if ban != nil {
+		device.Is.Ban = true
+		device.match = ban
+		return nil
 	}
+	errs = append(errs, err)
 
-	return int(count), nil
+	block, err := i.Manager.Block.isBlock(ip)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if block {
+		device.Is.Block = true
+		device.IP.BlockCount, err = i.blockCountInHour(ip)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
+// * blocks of the IP within the hour, counted while it is blocked
 func (i *IPGuardian) blockCountInHour(ip string) (int, error) {
-	if !i.Manager.Block.IsBlock(ip) {
-		return 0, nil
-	}
-
 	key := fmt.Sprintf(redisBlockCount, ip)
 
-	count, err := i.Redis.Incr(i.Context, key).Result() // * 自動計數
+	count, err := i.Store.Incr(i.Context, key) // * 自動計數
 	if err != nil {
 		return int(count), err
 	}
 
 	if count == 1 {
-		i.Redis.Expire(i.Context, key, 1*time.Hour)
+		i.Store.Expire(i.Context, key, 1*time.Hour)
 	}
 
 	return int(count), nil
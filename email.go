@@ -0,0 +1,237 @@
+package golangIPSentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// * email notification, see EmailConfig
+type SMTPNotifier struct {
+	Config *EmailConfig
+	// * default: net.Dialer, replace to test against a local SMTP stand-in
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// * default: ServerName of Config.Host
+	TLSConfig *tls.Config
+}
+
+func NewSMTPNotifier(config *EmailConfig) *SMTPNotifier {
+	return &SMTPNotifier{
+		Config: config,
+	}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, event Event) error {
+	subject := event.Message()
+	if event.Type == EventBan {
+		subject = fmt.Sprintf("[IP Sentry] IP %s has been banned", event.IP)
+	}
+	if n.Config.Subject != nil {
+		str := (*n.Config.Subject)(event.IP, event.Reason)
+		if str != "" {
+			subject = str
+		}
+	}
+	body := event.Message()
+	if n.Config.Body != nil {
+		str := (*n.Config.Body)(event.IP, event.Reason)
+		if str != "" {
+			body = str
+		}
+	}
+	htmlBody := "<p>" + strings.ReplaceAll(html.EscapeString(body), "\n", "<br>") + "</p>"
+	if n.Config.HTML != nil {
+		str := (*n.Config.HTML)(event.IP, event.Reason)
+		if str != "" {
+			htmlBody = str
+		}
+	}
+
+	msg, err := n.message(subject, body, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	return n.send(ctx, msg)
+}
+
+// * multipart/alternative with text and HTML parts, Bcc is not listed
+func (n *SMTPNotifier) message(subject, text, htmlBody string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := [][2]string{
+		{"From", n.Config.From},
+		{"To", strings.Join(n.Config.To, ", ")},
+	}
+	if len(n.Config.CC) > 0 {
+		headers = append(headers, [2]string{"Cc", strings.Join(n.Config.CC, ", ")})
+	}
+	headers = append(headers,
+		[2]string{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		[2]string{"Date", time.Now().Format(time.RFC1123Z)},
+		[2]string{"Message-ID", n.messageID()},
+		[2]string{"MIME-Version", "1.0"},
+		[2]string{"Content-Type", "multipart/alternative; boundary=" + strconv.Quote(writer.Boundary())},
+	)
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", htmlBody},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// * <random@domain of sender>
+func (n *SMTPNotifier) messageID() string {
+	domain := n.Config.Host
+	from := envelopeAddress(n.Config.From)
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	random := make([]byte, 16)
+	rand.Read(random)
+
+	return fmt.Sprintf("<%s.%d@%s>", hex.EncodeToString(random), time.Now().UnixNano(), domain)
+}
+
+func (n *SMTPNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(n.Config.Host, strconv.Itoa(n.Config.Port))
+
+	mode := n.Config.TLS
+	if mode == "" && n.Config.Port == 465 {
+		mode = EmailTLSImplicit
+	}
+
+	tlsConfig := n.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: n.Config.Host}
+	}
+
+	dial := n.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: smtpTimeout}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	// * whole session must finish in time, a stalled server must not hold the retry loop
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	if mode == EmailTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, n.Config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if mode == "" || mode == EmailTLSStartTLS {
+		ok, _ := client.Extension("STARTTLS")
+		switch {
+		case ok:
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start TLS: %w", err)
+			}
+		case mode == EmailTLSStartTLS:
+			return fmt.Errorf("SMTP server does not support STARTTLS")
+		}
+	}
+
+	// * no username, e.g. local relay
+	if n.Config.Username != "" {
+		auth := smtp.PlainAuth("", n.Config.Username, n.Config.Password, n.Config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(envelopeAddress(n.Config.From)); err != nil {
+		return err
+	}
+
+	// * Cc and Bcc are recipients as well
+	for _, list := range [][]string{n.Config.To, n.Config.CC, n.Config.BCC} {
+		for _, rcpt := range list {
+			if err := client.Rcpt(envelopeAddress(rcpt)); err != nil {
+				return fmt.Errorf("failed to add recipient %s: %w", rcpt, err)
+			}
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// * "Name <user@host>" to "user@host"
+func envelopeAddress(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		return addr.Address
+	}
+	return address
+}
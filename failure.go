@@ -0,0 +1,413 @@
+package golangIPSentry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// * Config.Failure.Mode, what Check does when the Store fails
+const (
+	FailOpen   = "open"   // * let the request through
+	FailClosed = "closed" // * reject with 503
+	FailLocal  = "local"  // * rate limit with an in-memory limiter of this instance
+)
+
+const (
+	defaultStoreTimeout     = 100 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+// * returned without calling the Store while the circuit is open
+// * custom stores can wrap it to report an outage, see storeOutage
+var ErrStoreUnavailable = errors.New("store: unavailable")
+
+// * transport, timeout and breaker errors, the Store could not answer
+// * others, e.g. script or value errors, are bugs and not handled by Config.Failure
+func storeOutage(err error) bool {
+	if err == nil || errors.Is(err, ErrNil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrStoreUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, redis.ErrClosed),
+		errors.Is(err, redis.ErrPoolTimeout),
+		errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// * opens after `threshold` consecutive failures, lets one trial call through after `cooldown`
+type breaker struct {
+	Logger    *Logger
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+	mutex     sync.Mutex
+}
+
+// * trial is true for the one call let through after the cooldown, pass it to done
+func (b *breaker) allow() (ok bool, trial bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false, false
+	}
+
+	b.trial = true
+	return true, true
+}
+
+// * only outages count, other errors mean the Store answered
+func (b *breaker) done(err error, trial bool) {
+	failed := storeOutage(err)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	if !failed {
+		if b.failures >= b.threshold {
+			b.Logger.Info("Store circuit closed")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures == b.threshold || trial {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.Logger.WarnError(err, fmt.Sprintf("Store circuit opened for %s", b.cooldown))
+	}
+}
+
+// * timeout and circuit breaker around every call of the Store
+type guardedStore struct {
+	store   Store
+	timeout time.Duration
+	breaker *breaker
+}
+
+// * PubSub of the wrapped Store is kept
+type guardedPubSubStore struct {
+	*guardedStore
+	pubsub PubSub
+}
+
+func newGuardedStore(store Store, config FailureConfig, logger *Logger) Store {
+	guarded := &guardedStore{
+		store:   store,
+		timeout: config.Timeout,
+		breaker: &breaker{
+			Logger:    logger,
+			threshold: config.Threshold,
+			cooldown:  config.Cooldown,
+		},
+	}
+
+	if pubsub, ok := store.(PubSub); ok {
+		return &guardedPubSubStore{
+			guardedStore: guarded,
+			pubsub:       pubsub,
+		}
+	}
+	return guarded
+}
+
+func (s *guardedStore) call(ctx context.Context, fn func(context.Context) error) error {
+	ok, trial := s.breaker.allow()
+	if !ok {
+		return ErrStoreUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := fn(ctx)
+	s.breaker.done(err, trial)
+	return err
+}
+
+func (s *guardedStore) Ping(ctx context.Context) error {
+	return s.call(ctx, s.store.Ping)
+}
+
+func (s *guardedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *guardedStore) Get(ctx context.Context, key string) (value string, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		value, err = s.store.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (s *guardedStore) MGet(ctx context.Context, keys ...string) (values []string, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		values, err = s.store.MGet(ctx, keys...)
+		return err
+	})
+	return values, err
+}
+
+func (s *guardedStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.Set(ctx, key, value, ttl)
+	})
+}
+
+func (s *guardedStore) SetVersion(ctx context.Context, key, versionKey string, version int64, value interface{}) (ok bool, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		ok, err = s.store.SetVersion(ctx, key, versionKey, version, value)
+		return err
+	})
+	return ok, err
+}
+
+func (s *guardedStore) Del(ctx context.Context, keys ...string) (count int64, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		count, err = s.store.Del(ctx, keys...)
+		return err
+	})
+	return count, err
+}
+
+func (s *guardedStore) Exists(ctx context.Context, key string) (exist bool, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		exist, err = s.store.Exists(ctx, key)
+		return err
+	})
+	return exist, err
+}
+
+func (s *guardedStore) Incr(ctx context.Context, key string) (count int64, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		count, err = s.store.Incr(ctx, key)
+		return err
+	})
+	return count, err
+}
+
+func (s *guardedStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.Expire(ctx, key, ttl)
+	})
+}
+
+func (s *guardedStore) TTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		ttl, err = s.store.TTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (s *guardedStore) Scan(ctx context.Context, pattern string) (keys []string, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		keys, err = s.store.Scan(ctx, pattern)
+		return err
+	})
+	return keys, err
+}
+
+func (s *guardedStore) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.SAdd(ctx, key, members...)
+	})
+}
+
+func (s *guardedStore) SCard(ctx context.Context, key string) (count int64, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		count, err = s.store.SCard(ctx, key)
+		return err
+	})
+	return count, err
+}
+
+func (s *guardedStore) LPush(ctx context.Context, key string, values ...interface{}) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.LPush(ctx, key, values...)
+	})
+}
+
+func (s *guardedStore) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.LTrim(ctx, key, start, stop)
+	})
+}
+
+func (s *guardedStore) LRange(ctx context.Context, key string, start, stop int64) (values []string, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		values, err = s.store.LRange(ctx, key, start, stop)
+		return err
+	})
+	return values, err
+}
+
+func (s *guardedStore) RateLimit(ctx context.Context, key string, algorithm string, limit RateLimit, consume bool) (result *RateLimitResult, err error) {
+	err = s.call(ctx, func(ctx context.Context) error {
+		result, err = s.store.RateLimit(ctx, key, algorithm, limit, consume)
+		return err
+	})
+	return result, err
+}
+
+func (s *guardedStore) Pipeline() Pipeline {
+	return &guardedPipeline{
+		store:    s,
+		pipeline: s.store.Pipeline(),
+	}
+}
+
+func (s *guardedPubSubStore) Publish(ctx context.Context, channel string, message string) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.pubsub.Publish(ctx, channel, message)
+	})
+}
+
+// * long lived, not bound by the call timeout
+func (s *guardedPubSubStore) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	return s.pubsub.Subscribe(ctx, channel)
+}
+
+// * Exec is one call, queued commands fail with it when the circuit is open
+type guardedPipeline struct {
+	store    *guardedStore
+	pipeline Pipeline
+	cmds     []*StoreCmd
+}
+
+func (p *guardedPipeline) add(cmd *StoreCmd) *StoreCmd {
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *guardedPipeline) Get(ctx context.Context, key string) *StoreCmd {
+	return p.add(p.pipeline.Get(ctx, key))
+}
+
+func (p *guardedPipeline) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *StoreCmd {
+	return p.add(p.pipeline.Set(ctx, key, value, ttl))
+}
+
+func (p *guardedPipeline) Del(ctx context.Context, keys ...string) *StoreCmd {
+	return p.add(p.pipeline.Del(ctx, keys...))
+}
+
+func (p *guardedPipeline) Incr(ctx context.Context, key string) *StoreCmd {
+	return p.add(p.pipeline.Incr(ctx, key))
+}
+
+func (p *guardedPipeline) Expire(ctx context.Context, key string, ttl time.Duration) *StoreCmd {
+	return p.add(p.pipeline.Expire(ctx, key, ttl))
+}
+
+func (p *guardedPipeline) SAdd(ctx context.Context, key string, members ...interface{}) *StoreCmd {
+	return p.add(p.pipeline.SAdd(ctx, key, members...))
+}
+
+func (p *guardedPipeline) SCard(ctx context.Context, key string) *StoreCmd {
+	return p.add(p.pipeline.SCard(ctx, key))
+}
+
+func (p *guardedPipeline) LPush(ctx context.Context, key string, values ...interface{}) *StoreCmd {
+	return p.add(p.pipeline.LPush(ctx, key, values...))
+}
+
+func (p *guardedPipeline) LTrim(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	return p.add(p.pipeline.LTrim(ctx, key, start, stop))
+}
+
+func (p *guardedPipeline) LRange(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	return p.add(p.pipeline.LRange(ctx, key, start, stop))
+}
+
+func (p *guardedPipeline) Exec(ctx context.Context) error {
+	err := p.store.call(ctx, p.pipeline.Exec)
+	if err == ErrStoreUnavailable {
+		for _, cmd := range p.cmds {
+			cmd.err = err
+		}
+	}
+	return err
+}
+
+// * store error during a check, an outage is decided by Failure.Mode and marked Degraded
+// * any other error rejects with 500
+func (i *IPGuardian) degrade(p *policy, device *Device, r *route, score *ScoreItem, result IPGuardianResult, err error) IPGuardianResult {
+	if !storeOutage(err) {
+		i.Logger.Error(err, "Failed to check request, IP: "+device.IP.Address)
+		result.Success = false
+		result.StatusCode = http.StatusInternalServerError
+		result.Reason = ReasonCheckError
+		result.Error = "Failed to check request, IP: " + device.IP.Address
+		return result
+	}
+	if err != ErrStoreUnavailable {
+		i.Logger.WarnError(err, "Failed to check request, IP: "+device.IP.Address)
+	}
+
+	result.Degraded = true
+	switch i.Config.Failure.Mode {
+	case FailOpen:
+		result.Reason = ReasonFailOpen
+		return result
+	case FailClosed:
+		result.Success = false
+		result.StatusCode = http.StatusServiceUnavailable
+		result.Reason = ReasonFailClosed
+		result.Error = "Store is unavailable, IP: " + device.IP.Address
+		return result
+	}
+
+	if score == nil {
+		score = &ScoreItem{}
+	}
+	limit, _, level := routeRateLimit(p, r, score)
+
+	key := rateLimitKey(p, device.IP.Address, r)
+	rate, err := i.fallback.RateLimit(i.Context, key, p.RateLimitAlgorithm, limit, true)
+	if err != nil {
+		// * limits are validated in New, not expected
+		result.Reason = ReasonFailOpen
+		return result
+	}
+
+	result.Reason = ReasonFailLocal
+	result.RateLimit = rate
+	if !rate.Allowed {
+		result.Success = false
+		result.StatusCode = http.StatusTooManyRequests
+		result.Error = "Device is reached local rate limit (" + level + "), IP: " + device.IP.Address
+	}
+	return result
+}
@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
-	"github.com/redis/go-redis/v9"
 )
 
 type GeoLite2Config struct {
@@ -22,11 +21,11 @@ type GeoLite2Config struct {
 type GeoLite2 struct {
 	Logger    *Logger
 	Config    *Config
-	Redis     *redis.Client
+	Store     Store
+	Metrics   Metrics
 	Context   context.Context
 	CityDB    *geoip2.Reader
 	CountryDB *geoip2.Reader
-	HighRisk  map[string]bool
 }
 
 type Location struct {
@@ -44,11 +43,11 @@ type Location struct {
 
 func (i *IPGuardian) newGeoLite2() *GeoLite2 {
 	checker := &GeoLite2{
-		Logger:   i.Logger,
-		Config:   i.Config,
-		Redis:    i.Redis,
-		Context:  i.Context,
-		HighRisk: map[string]bool{},
+		Logger:  i.Logger,
+		Config:  i.Config,
+		Store:   i.Store,
+		Metrics: i.Metrics,
+		Context: i.Context,
 	}
 
 	if i.Config.Filepath.CityDB == "" && i.Config.Filepath.CountryDB == "" {
@@ -76,7 +75,7 @@ func (i *IPGuardian) newGeoLite2() *GeoLite2 {
 	return checker
 }
 
-func (c *GeoLite2) location(ip string) (*Location, error) {
+func (c *GeoLite2) location(ctx context.Context, ip string) (*Location, error) {
 	if c == nil {
 		return &Location{
 			IP: ip,
@@ -89,20 +88,23 @@ func (c *GeoLite2) location(ip string) (*Location, error) {
 		}, nil
 	}
 
-	if location := c.get(ip); location != nil {
+	if location := c.get(ctx, ip); location != nil {
+		c.Metrics.ObserveGeoLookup(GeoLookupCache)
 		return location, nil
 	}
 
 	location, err := c.query(ip)
 	if err != nil {
+		c.Metrics.ObserveGeoLookup(GeoLookupMiss)
 		return &Location{
 			IP: ip,
 		}, err
 	}
 
+	c.Metrics.ObserveGeoLookup(GeoLookupHit)
 	location.IP = ip
 
-	c.set(ip, location)
+	c.set(ctx, ip, location)
 
 	return location, nil
 }
@@ -149,10 +151,10 @@ func (c *GeoLite2) query(ip string) (*Location, error) {
 }
 
 // * Get from redis
-func (c *GeoLite2) get(ip string) *Location {
+func (c *GeoLite2) get(ctx context.Context, ip string) *Location {
 	key := fmt.Sprintf(redisGeoIP, ip)
 
-	data, err := c.Redis.Get(c.Context, key).Result()
+	data, err := c.Store.Get(ctx, key)
 	if err != nil {
 		return nil
 	}
@@ -166,7 +168,7 @@ func (c *GeoLite2) get(ip string) *Location {
 }
 
 // * Set to redis
-func (c *GeoLite2) set(ip string, location *Location) {
+func (c *GeoLite2) set(ctx context.Context, ip string, location *Location) {
 	key := fmt.Sprintf(redisGeoIP, ip)
 
 	data, err := json.Marshal(location)
@@ -174,7 +176,7 @@ func (c *GeoLite2) set(ip string, location *Location) {
 		return
 	}
 
-	c.Redis.SetEx(c.Context, key, data, 24*time.Hour)
+	c.Store.Set(ctx, key, data, 24*time.Hour)
 }
 
 func (c *GeoLite2) close() {
@@ -188,7 +190,7 @@ func (c *GeoLite2) close() {
 	}
 }
 
-func (c *GeoLite2) risk(locations []string, flags *[]string, riskScore *RiskScore) error {
+func (c *GeoLite2) risk(p *policy, locations []string, flags *[]string, riskScore *RiskScore) error {
 	if len(locations) == 0 {
 		return nil
 	}
@@ -229,40 +231,32 @@ func (c *GeoLite2) risk(locations []string, flags *[]string, riskScore *RiskScor
 		return nil
 	}
 
-	c.checkHighRisk(list, flags, riskScore)
-	c.checkHopping(list, flags, riskScore)
-	c.checkFrequentSwitch(list, flags, riskScore)
-	c.checkRapidChange(list, flags, riskScore)
+	c.checkHighRisk(p, list, flags, riskScore)
+	c.checkHopping(p, list, flags, riskScore)
+	c.checkFrequentSwitch(p, list, flags, riskScore)
+	c.checkRapidChange(p, list, flags, riskScore)
 
 	return nil
 }
 
-func (c *GeoLite2) checkHighRisk(locations []Location, flags *[]string, riskScore *RiskScore) {
+func (c *GeoLite2) checkHighRisk(p *policy, locations []Location, flags *[]string, riskScore *RiskScore) {
 	list := make(map[string]bool)
-	riskList := make(map[string]bool)
-	for _, country := range c.Config.Parameter.HighRiskCountry {
-		riskList[country] = true
-	}
 
 	for _, loc := range locations {
-		if riskList[loc.CountryCode] {
+		if p.highRiskCountry[loc.CountryCode] {
 			list[loc.Country] = true
 		}
 	}
 
-	if c.Config.Parameter.ScoreGeoHighRisk <= 0 {
-		c.Config.Parameter.ScoreGeoHighRisk = 30
-	}
-
 	if len(list) > 0 {
 		*flags = append(*flags, "geo_high_risk")
-		riskScore.Base += c.Config.Parameter.ScoreGeoHighRisk
+		riskScore.Base += p.ScoreGeoHighRisk
 		riskScore.Detail["geoCountries"] = len(list)
 		riskScore.Detail["countries"] = getMapKeys(list)
 	}
 }
 
-func (c *GeoLite2) checkHopping(locations []Location, flags *[]string, riskScore *RiskScore) {
+func (c *GeoLite2) checkHopping(p *policy, locations []Location, flags *[]string, riskScore *RiskScore) {
 	list := make(map[string]bool)
 
 	for _, loc := range locations {
@@ -271,20 +265,16 @@ func (c *GeoLite2) checkHopping(locations []Location, flags *[]string, riskScore
 		}
 	}
 
-	if c.Config.Parameter.ScoreGeoHopping <= 0 {
-		c.Config.Parameter.ScoreGeoHopping = 15
-	}
-
 	// * 一小時內4個不同國家
 	if len(list) > 4 {
 		*flags = append(*flags, "geo_hopping")
-		riskScore.Base += c.Config.Parameter.ScoreGeoHopping
+		riskScore.Base += p.ScoreGeoHopping
 		riskScore.Detail["geoCountries"] = len(list)
 		riskScore.Detail["countries"] = getMapKeys(list)
 	}
 }
 
-func (c *GeoLite2) checkFrequentSwitch(locations []Location, flags *[]string, riskScore *RiskScore) {
+func (c *GeoLite2) checkFrequentSwitch(p *policy, locations []Location, flags *[]string, riskScore *RiskScore) {
 	var locationList []Location
 	cityList := make(map[string]bool)
 
@@ -307,19 +297,15 @@ func (c *GeoLite2) checkFrequentSwitch(locations []Location, flags *[]string, ri
 		}
 	}
 
-	if c.Config.Parameter.ScoreGeoFrequentSwitch <= 0 {
-		c.Config.Parameter.ScoreGeoFrequentSwitch = 20
-	}
-
 	if switchCount > 4 {
 		*flags = append(*flags, "geo_frequent_switching")
-		riskScore.Base += c.Config.Parameter.ScoreGeoFrequentSwitch
+		riskScore.Base += p.ScoreGeoFrequentSwitch
 		riskScore.Detail["geoSwitches"] = switchCount
 		riskScore.Detail["switchCities"] = getMapKeys(cityList)
 	}
 }
 
-func (c *GeoLite2) checkRapidChange(locations []Location, flags *[]string, riskScore *RiskScore) {
+func (c *GeoLite2) checkRapidChange(p *policy, locations []Location, flags *[]string, riskScore *RiskScore) {
 	if len(locations) < 2 {
 		return
 	}
@@ -337,14 +323,11 @@ func (c *GeoLite2) checkRapidChange(locations []Location, flags *[]string, riskS
 	hour := float64(timeDiff) / 3600000
 	speed := distance / hour
 
-	if c.Config.Parameter.ScoreGeoRapidChange <= 0 {
-		c.Config.Parameter.ScoreGeoRapidChange = 25
-	}
 	// * 移動速度超過800公里/小時
 	// * 距離超過500公里且在30分鐘內
 	if speed > 800 || (distance > 500 && timeDiff < 1800000) {
 		*flags = append(*flags, "rapid_geo_change")
-		riskScore.Base += c.Config.Parameter.ScoreGeoRapidChange
+		riskScore.Base += p.ScoreGeoRapidChange
 		riskScore.Detail["rapidGeoChange"] = map[string]interface{}{
 			"from":     fmt.Sprintf("%s:%s", prev.Country, prev.City),
 			"to":       fmt.Sprintf("%s:%s", recent.Country, recent.City),
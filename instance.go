@@ -3,7 +3,10 @@ package golangIPSentry
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	goLogger "github.com/pardnchiu/go-logger"
 	"github.com/redis/go-redis/v9"
@@ -25,34 +28,76 @@ func New(c Config) (*IPGuardian, error) {
 		c.Redis.Port = 6379
 	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", c.Redis.Host, c.Redis.Port),
-		Password: c.Redis.Password,
-		DB:       c.Redis.DB,
-	})
-	if _, err := redisClient.Ping(context.Background()).Result(); err != nil {
-		return nil, logger.Error(err, "Failed to connect Redis")
+	if err := c.Validate(); err != nil {
+		return nil, logger.Error(err, "Failed to validate config")
 	}
 
-	// var abuseIPDBApi *AbuseIPDBApi
-	// if c.AbuseIPDBToken != "" {
-	// 	abuseIPDBApi = &AbuseIPDBApi{
-	// 		Context: context.Background(),
-	// 		IsPaid:  c.AbuseIPDBIsPaid,
-	// 		Redis:   redisClient,
-	// 		HTTP:    &http.Client{Timeout: 10 * time.Second},
-	// 		Token:   c.AbuseIPDBToken,
-	// 		Logger:  logger,
-	// 	}
-	// }
+	if c.Mode == "" {
+		c.Mode = ModeEnforce
+	}
+
+	c.Failure = validFailureConfig(c.Failure)
+
+	policy, err := resolvePolicy(c.Parameter)
+	if err != nil {
+		return nil, logger.Error(err, "Failed to validate config")
+	}
+
+	trustedProxies, err := parseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, logger.Error(err, "Failed to parse trusted proxies")
+	}
+
+	routes, err := compileRoutes(c.Routes)
+	if err != nil {
+		return nil, logger.Error(err, "Failed to compile routes")
+	}
+
+	store := c.Store
+	if store == nil {
+		store = NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", c.Redis.Host, c.Redis.Port),
+			Password: c.Redis.Password,
+			DB:       c.Redis.DB,
+			// * per-call deadlines of Failure.Timeout
+			ContextTimeoutEnabled: true,
+		}))
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		return nil, logger.Error(err, "Failed to connect store")
+	}
+	store = newGuardedStore(store, c.Failure, logger)
+
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
 
 	instance := &IPGuardian{
-		Context: context.Background(),
-		Config:  &c,
-		Redis:   redisClient,
-		Logger:  logger,
-		// AbuseIPDBApi: abuseIPDBApi,
+		Context:        context.Background(),
+		Config:         &c,
+		Store:          store,
+		Logger:         logger,
+		Metrics:        metrics,
+		trustedProxies: trustedProxies,
+		routes:         routes,
+	}
+	instance.policy.Store(policy)
+	if c.Failure.Mode == FailLocal {
+		instance.fallback = NewMemoryStore()
+	}
+
+	notifier, err := instance.newNotifyDispatcher()
+	if err != nil {
+		return nil, logger.Error(err, "Failed to initialize notifiers")
 	}
+	instance.notifier = notifier
+
+	audit, err := newAuditLog(c.Audit, c.Log)
+	if err != nil {
+		return nil, logger.Error(err, "Failed to initialize audit log")
+	}
+	instance.audit = audit
 
 	instance.Manager = &Manager{
 		Allow: instance.newAllowManager(),
@@ -60,17 +105,67 @@ func New(c Config) (*IPGuardian, error) {
 		Block: instance.newBlocIPkManager(),
 	}
 
+	// * runtime updates in the Store take precedence over Parameter
+	instance.syncPolicy()
+	instance.subscribePolicy()
+
+	if interval := c.PolicyInterval; interval >= 0 {
+		if interval == 0 {
+			interval = defaultPolicyInterval
+		}
+		instance.pollStop = make(chan struct{})
+		instance.pollDone = make(chan struct{})
+		go instance.pollPolicy(interval)
+	} else if instance.policyCancel == nil {
+		logger.Warn("Policy updates of other instances are not applied", "store has no PubSub and policy_interval is negative")
+	} else {
+		logger.Info("Policy polling disabled", "policy_interval is negative, updates are received through PubSub only")
+	}
+
+	if interval := c.Filepath.ReloadInterval; interval >= 0 {
+		if interval == 0 {
+			interval = defaultReloadInterval
+		}
+		instance.watchStop = make(chan struct{})
+		instance.watchDone = make(chan struct{})
+		go instance.watchLists(interval)
+	} else {
+		logger.Info("List reload disabled", "reload_interval is negative, list file changes apply on restart")
+	}
+
 	instance.GeoLite2 = instance.newGeoLite2()
+	instance.AbuseIPDBApi = instance.newAbuseIPDBApi()
 
 	return instance, nil
 }
 
 func (i *IPGuardian) Close() error {
-	if i.Redis != nil {
-		if err := i.Redis.Close(); err != nil {
+	if i.watchStop != nil {
+		close(i.watchStop)
+		<-i.watchDone
+		i.watchStop = nil
+	}
+	if i.pollStop != nil {
+		close(i.pollStop)
+		<-i.pollDone
+		i.pollStop = nil
+	}
+	if i.policyCancel != nil {
+		i.policyCancel()
+		<-i.policyDone
+		i.policyCancel = nil
+	}
+	i.notifier.close()
+	i.audit.close()
+
+	if i.Store != nil {
+		if err := i.Store.Close(); err != nil {
 			return err
 		}
 	}
+	if i.fallback != nil {
+		i.fallback.Close()
+	}
 	if i.GeoLite2 != nil {
 		i.GeoLite2.close()
 	}
@@ -81,115 +176,229 @@ func (i *IPGuardian) Close() error {
 }
 
 type IPGuardianResult struct {
-	Success    bool   `json:"success"`
-	StatusCode int    `json:"status_code"`
-	Error      string `json:"error"`
+	Success       bool                   `json:"success"`
+	StatusCode    int                    `json:"status_code"`
+	Error         string                 `json:"error"`
+	Reason        string                 `json:"reason"`
+	Score         int                    `json:"score"`
+	Flags         []string               `json:"flags,omitempty"`
+	Detail        map[string]interface{} `json:"detail,omitempty"`     // * ScoreItem.Detail breakdown
+	Match         *IPItem                `json:"match,omitempty"`      // * matched allow, deny or block entry
+	BlockTTL      time.Duration          `json:"block_ttl,omitempty"`  // * remaining block time
+	RateLimit     *RateLimitResult       `json:"rate_limit,omitempty"` // * state of the applied rate limit tier
+	Route         string                 `json:"route,omitempty"`      // * name of the matched route policy
+	PolicyVersion int64                  `json:"policy_version"`       // * policy version the check ran with
+	Suspicious    bool                   `json:"suspicious,omitempty"` // * score reached the suspicious threshold of the route
+	IP            string                 `json:"ip,omitempty"`         // * resolved client IP
+	SessionID     string                 `json:"session_id,omitempty"`
+	Fingerprint   string                 `json:"fingerprint,omitempty"`
+	DryRun        bool                   `json:"dry_run,omitempty"`  // * monitor mode, the request is never rejected
+	Decision      *Decision              `json:"decision,omitempty"` // * what enforce mode would have returned
+	Degraded      bool                   `json:"degraded,omitempty"` // * the Store failed, decided by Failure.Mode
+}
+
+// * decision recorded in monitor mode
+type Decision struct {
+	StatusCode int      `json:"status_code"`
+	Reason     string   `json:"reason"`
+	Score      int      `json:"score"`
+	Flags      []string `json:"flags,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// * which list or rate tier fired
+const (
+	ReasonPass           = "pass"
+	ReasonRouteSkip      = "route_skip"
+	ReasonDeviceError    = "device_error"
+	ReasonCheckError     = "check_error" // * unexpected Store error, rejected with 500
+	ReasonAllowList      = "allow_list"
+	ReasonBlockList      = "block_list"
+	ReasonDenyList       = "deny_list"
+	ReasonBlockToBan     = "block_to_ban"
+	ReasonScoreBlock     = "score_block"
+	ReasonRateDangerous  = "rate_limit_dangerous"
+	ReasonRateSuspicious = "rate_limit_suspicious"
+	ReasonRateNormal     = "rate_limit_normal"
+	ReasonFailOpen       = "fail_open"   // * Store unavailable, passed
+	ReasonFailClosed     = "fail_closed" // * Store unavailable, rejected
+	ReasonFailLocal      = "fail_local"  // * Store unavailable, limited in memory
+)
+
+func (i *IPGuardian) isMonitor() bool {
+	return i.Config.Mode == ModeMonitor
 }
 
 func (i *IPGuardian) Check(r *http.Request, w http.ResponseWriter) IPGuardianResult {
-	device, err := i.getDevice(w, r)
-	if err != nil {
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusInternalServerError,
-			Error:      "Failed to get device info",
-		}
+	return i.CheckRequest(NewHTTPRequest(w, r))
+}
+
+// * Check for any framework, see Request
+func (i *IPGuardian) CheckRequest(req Request) IPGuardianResult {
+	result := i.check(req)
+
+	if !i.isMonitor() {
+		i.writeHeaders(req, result)
+		i.Metrics.ObserveCheck(result)
+		i.auditCheck(req, result)
+		return result
 	}
 
-	if device.Is.Trust {
-		// * this device is trusted, skip further checks
-		return IPGuardianResult{
-			Success:    true,
-			StatusCode: http.StatusOK,
-		}
+	decision := &Decision{
+		StatusCode: result.StatusCode,
+		Reason:     result.Reason,
+		Score:      result.Score,
+		Flags:      result.Flags,
+		Error:      result.Error,
 	}
 
-	if device.Is.Block {
-		// * this device is banned, return error
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is blocked, IP: " + device.IP.Address,
-		}
+	if !result.Success {
+		i.Logger.Info("Monitor mode would reject request",
+			"reason: "+decision.Reason,
+			fmt.Sprintf("status: %d", decision.StatusCode),
+			fmt.Sprintf("score: %d", decision.Score),
+			"error: "+decision.Error,
+		)
 	}
 
-	if device.Is.Ban {
-		// * this device is banned, return error
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is banned, IP: " + device.IP.Address,
-		}
+	result.Success = true
+	result.StatusCode = http.StatusOK
+	result.Error = ""
+	result.DryRun = true
+	result.Decision = decision
+
+	i.writeHeaders(req, result)
+	i.Metrics.ObserveCheck(result)
+	i.auditCheck(req, result)
+
+	return result
+}
+
+func (i *IPGuardian) check(r Request) IPGuardianResult {
+	result := IPGuardianResult{
+		Success:    true,
+		StatusCode: http.StatusOK,
+		Reason:     ReasonPass,
 	}
 
-	// * auto add to ban list if device is blocked and continue request
+	// * one version for the whole check
+	p := i.policy.Load()
+	result.PolicyVersion = p.version
+
+	reject := func(statusCode int, reason, message string) IPGuardianResult {
+		result.Success = false
+		result.StatusCode = statusCode
+		result.Reason = reason
+		result.Error = message
+		return result
+	}
 
-	if i.Config.Parameter.BlockToBan <= 0 {
-		i.Config.Parameter.BlockToBan = 8
+	route := i.matchRoute(r)
+	if route != nil {
+		result.Route = route.policy.Name
+		if route.policy.Skip {
+			// * opted out, e.g. health checks
+			result.Reason = ReasonRouteSkip
+			return result
+		}
 	}
 
-	if i.Config.Parameter.RateLimitNormal <= 0 {
-		i.Config.Parameter.RateLimitNormal = 100
+	start := time.Now()
+	device, err := i.getDevice(r)
+	i.Metrics.ObservePhase("device", time.Since(start))
+	if err != nil {
+		return reject(http.StatusInternalServerError, ReasonDeviceError, "Failed to get device info")
 	}
-	if i.Config.Parameter.RateLimitSuspicious <= 0 {
-		i.Config.Parameter.RateLimitSuspicious = 50
+
+	result.IP = device.IP.Address
+	result.SessionID = device.SessionID
+	result.Fingerprint = device.Fingerprint
+
+	if device.Is.Trust {
+		// * this device is trusted, skip further checks
+		result.Reason = ReasonAllowList
+		result.Match = device.match
+		return result
 	}
-	if i.Config.Parameter.RateLimitDangerous <= 0 {
-		i.Config.Parameter.RateLimitDangerous = 20
+
+	if device.Is.Ban {
+		// * this device is banned, return error
+		result.Match = device.match
+		return reject(http.StatusForbidden, ReasonDenyList, "Device is banned, IP: "+device.IP.Address)
 	}
 
-	if device.Is.Block && device.IP.BlockCount >= i.Config.Parameter.BlockToBan {
-		i.Manager.Deny.Add(device.IP.Address, "Device is blocked and continue to request, IP: "+device.IP.Address)
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is banned, IP: " + device.IP.Address,
+	if device.Is.Block {
+		// * auto add to ban list if device is blocked and continue request
+		if reached(device.IP.BlockCount, p.BlockToBan) {
+			if !i.isMonitor() {
+				i.Manager.Deny.Add(device.IP.Address, "Device is blocked and continue to request, IP: "+device.IP.Address)
+				result.Match, _ = i.Manager.Deny.Get(device.IP.Address)
+			}
+			return reject(http.StatusForbidden, ReasonBlockToBan, "Device is banned, IP: "+device.IP.Address)
 		}
+
+		// * this device is blocked, return error
+		result.Match, _ = i.Manager.Block.Get(device.IP.Address)
+		result.BlockTTL, _ = i.Manager.Block.TTL(device.IP.Address)
+		return reject(http.StatusForbidden, ReasonBlockList, "Device is blocked, IP: "+device.IP.Address)
+	}
+
+	if device.storeErr != nil {
+		// * list state is unknown, lists matched in memory are already handled
+		return i.degrade(p, device, route, nil, result, device.storeErr)
 	}
 
-	score, err := i.dynamicScore(device)
+	score, err := i.dynamicScore(p, device, route)
 	if err != nil {
-		// TODO: 後續要改寫，不能直接通過
-		i.Logger.Error(err, "Failed to detect suspicious activity")
+		return i.degrade(p, device, route, nil, result, err)
 	}
 
+	result.Score = score.Score
+	result.Flags = score.Flag
+	result.Detail = score.Detail
+	result.Suspicious = score.IsSuspicious
+
 	if score.IsBlock {
 		// * dynamicScore 已自動添加至 blocklist，不需重複添加
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is blocked, IP: " + device.IP.Address,
-		}
+		result.Match, _ = i.Manager.Block.Get(device.IP.Address)
+		result.BlockTTL, _ = i.Manager.Block.TTL(device.IP.Address)
+		return reject(http.StatusForbidden, ReasonScoreBlock, "Device is blocked, IP: "+device.IP.Address)
 	}
 
-	if score.IsDangerous && device.IP.RequestCount >= i.Config.Parameter.RateLimitDangerous {
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is reached rate limit (Dangerous), IP: " + device.IP.Address,
-		}
-	}
-	if score.IsSuspicious && device.IP.RequestCount >= i.Config.Parameter.RateLimitSuspicious {
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is reached rate limit (Suspicious), IP: " + device.IP.Address,
-		}
+	// * the strictest tier of the score applies
+	limit, reason, level := routeRateLimit(p, route, score)
+
+	start = time.Now()
+	rate, err := i.rateLimit(p, device.IP.Address, route, limit, true)
+	i.Metrics.ObservePhase("rate_limit", time.Since(start))
+	if err != nil {
+		return i.degrade(p, device, route, score, result, err)
 	}
-	if device.IP.RequestCount >= i.Config.Parameter.RateLimitNormal {
-		return IPGuardianResult{
-			Success:    false,
-			StatusCode: http.StatusForbidden,
-			Error:      "Device is reached rate limit (Normal), IP: " + device.IP.Address,
-		}
+
+	device.IP.RequestCount = rate.Count
+	result.RateLimit = rate
+
+	if !rate.Allowed {
+		return reject(http.StatusTooManyRequests, reason, "Device is reached rate limit ("+level+"), IP: "+device.IP.Address)
 	}
 
-	// TODO: AbuseIPDB 檢查未完成
+	return result
+}
 
-	return IPGuardianResult{
-		Success:    true,
-		StatusCode: http.StatusOK,
+func validFailureConfig(c FailureConfig) FailureConfig {
+	if c.Mode == "" {
+		c.Mode = FailLocal
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultStoreTimeout
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultBreakerThreshold
 	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = defaultBreakerCooldown
+	}
+	return c
 }
 
 func validLoggerConfig(c Config) *Log {
@@ -211,3 +420,36 @@ func validLoggerConfig(c Config) *Log {
 	}
 	return c.Log
 }
+
+// * Retry-After for rate limit and block rejections, IETF RateLimit headers from the applied tier
+func (i *IPGuardian) writeHeaders(req Request, result IPGuardianResult) {
+	rate := result.RateLimit
+
+	if !result.Success {
+		switch {
+		case result.StatusCode == http.StatusTooManyRequests && rate != nil:
+			req.SetHeader("Retry-After", headerSeconds(rate.RetryAfter, 1))
+		case result.BlockTTL > 0:
+			// * remaining TTL of the block, permanent blocks have none
+			req.SetHeader("Retry-After", headerSeconds(result.BlockTTL, 1))
+		}
+	}
+
+	if rate == nil || (result.Success && !i.Config.RateLimitHeaders) {
+		return
+	}
+
+	req.SetHeader("RateLimit-Limit", strconv.Itoa(rate.Limit))
+	req.SetHeader("RateLimit-Remaining", strconv.Itoa(rate.Remaining))
+	req.SetHeader("RateLimit-Reset", headerSeconds(rate.Reset, 0))
+	req.SetHeader("RateLimit-Policy", fmt.Sprintf("%d;w=%s", rate.Limit, headerSeconds(rate.Window, 1)))
+}
+
+// * delta seconds rounded up
+func headerSeconds(d time.Duration, min int64) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < min {
+		seconds = min
+	}
+	return strconv.FormatInt(seconds, 10)
+}
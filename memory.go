@@ -0,0 +1,557 @@
+package golangIPSentry
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// * in-memory store for single instance services and tests
+type MemoryStore struct {
+	mutex       sync.Mutex
+	data        map[string]*memoryEntry
+	subscribers map[string][]chan string
+	stop        chan struct{}
+	once        sync.Once
+}
+
+type memoryEntry struct {
+	str    string
+	set    map[string]struct{}
+	list   []string
+	rate   *memoryRate
+	expire time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		data:        make(map[string]*memoryEntry),
+		subscribers: make(map[string][]chan string),
+		stop:        make(chan struct{}),
+	}
+
+	go store.sweep()
+
+	return store
+}
+
+// * remove expired keys every minute
+func (s *MemoryStore) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mutex.Lock()
+			for key, entry := range s.data {
+				if !entry.expire.IsZero() && now.After(entry.expire) {
+					delete(s.data, key)
+				}
+			}
+			s.mutex.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+// * caller must hold the mutex
+func (s *MemoryStore) entry(key string) *memoryEntry {
+	entry, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expire.IsZero() && time.Now().After(entry.expire) {
+		delete(s.data, key)
+		return nil
+	}
+	return entry
+}
+
+func (s *MemoryStore) get(key string) (string, error) {
+	entry := s.entry(key)
+	if entry == nil {
+		return "", ErrNil
+	}
+	return entry.str, nil
+}
+
+func (s *MemoryStore) set(key string, value interface{}, ttl time.Duration) {
+	entry := &memoryEntry{
+		str: storeString(value),
+	}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	s.data[key] = entry
+}
+
+func (s *MemoryStore) del(keys ...string) int64 {
+	var count int64
+	for _, key := range keys {
+		if s.entry(key) != nil {
+			delete(s.data, key)
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStore) incr(key string) (int64, error) {
+	entry := s.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{str: "0"}
+		s.data[key] = entry
+	}
+
+	value, err := strconv.ParseInt(entry.str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	value++
+	entry.str = strconv.FormatInt(value, 10)
+
+	return value, nil
+}
+
+func (s *MemoryStore) expire(key string, ttl time.Duration) bool {
+	entry := s.entry(key)
+	if entry == nil {
+		return false
+	}
+	if ttl <= 0 {
+		delete(s.data, key)
+		return true
+	}
+	entry.expire = time.Now().Add(ttl)
+	return true
+}
+
+func (s *MemoryStore) sadd(key string, members ...interface{}) int64 {
+	entry := s.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{}
+		s.data[key] = entry
+	}
+	if entry.set == nil {
+		entry.set = make(map[string]struct{})
+	}
+
+	var count int64
+	for _, member := range members {
+		str := storeString(member)
+		if _, ok := entry.set[str]; !ok {
+			entry.set[str] = struct{}{}
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStore) scard(key string) int64 {
+	entry := s.entry(key)
+	if entry == nil {
+		return 0
+	}
+	return int64(len(entry.set))
+}
+
+func (s *MemoryStore) lpush(key string, values ...interface{}) int64 {
+	entry := s.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{}
+		s.data[key] = entry
+	}
+
+	for _, value := range values {
+		entry.list = append([]string{storeString(value)}, entry.list...)
+	}
+	return int64(len(entry.list))
+}
+
+// * redis style index, negative counts from the end
+func listRange(length int, start, stop int64) (int, int) {
+	n := int64(length)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func (s *MemoryStore) ltrim(key string, start, stop int64) {
+	entry := s.entry(key)
+	if entry == nil {
+		return
+	}
+
+	from, to := listRange(len(entry.list), start, stop)
+	if from == to {
+		delete(s.data, key)
+		return
+	}
+	entry.list = append([]string{}, entry.list[from:to]...)
+}
+
+func (s *MemoryStore) lrange(key string, start, stop int64) []string {
+	entry := s.entry(key)
+	if entry == nil {
+		return []string{}
+	}
+
+	from, to := listRange(len(entry.list), start, stop)
+	return append([]string{}, entry.list[from:to]...)
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.get(key)
+}
+
+func (s *MemoryStore) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]string, len(keys))
+	for idx, key := range keys {
+		list[idx], _ = s.get(key)
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.set(key, value, ttl)
+	return nil
+}
+
+func (s *MemoryStore) SetVersion(ctx context.Context, key, versionKey string, version int64, value interface{}) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current, err := s.get(versionKey); err == nil {
+		stored, err := strconv.ParseInt(current, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		if version <= stored {
+			return false, nil
+		}
+	}
+
+	s.set(key, value, 0)
+	s.set(versionKey, version, 0)
+	return true, nil
+}
+
+func (s *MemoryStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.del(keys...), nil
+}
+
+func (s *MemoryStore) Exists(ctx context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.entry(key) != nil, nil
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.incr(key)
+}
+
+func (s *MemoryStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(key, ttl)
+	return nil
+}
+
+func (s *MemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.entry(key)
+	if entry == nil {
+		return -2, nil
+	}
+	if entry.expire.IsZero() {
+		return -1, nil
+	}
+	return time.Until(entry.expire), nil
+}
+
+func (s *MemoryStore) Scan(ctx context.Context, pattern string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var keys []string
+	for key := range s.data {
+		if s.entry(key) != nil && matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sadd(key, members...)
+	return nil
+}
+
+func (s *MemoryStore) SCard(ctx context.Context, key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.scard(key), nil
+}
+
+func (s *MemoryStore) LPush(ctx context.Context, key string, values ...interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lpush(key, values...)
+	return nil
+}
+
+func (s *MemoryStore) LTrim(ctx context.Context, key string, start, stop int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ltrim(key, start, stop)
+	return nil
+}
+
+func (s *MemoryStore) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lrange(key, start, stop), nil
+}
+
+func (s *MemoryStore) RateLimit(ctx context.Context, key string, algorithm string, limit RateLimit, consume bool) (*RateLimitResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{}
+	}
+	if entry.rate == nil {
+		entry.rate = &memoryRate{}
+	}
+
+	now := time.Now()
+	result, ttl, err := entry.rate.take(algorithm, limit, now, consume)
+	if err != nil {
+		return nil, err
+	}
+
+	if consume && result.Allowed {
+		entry.expire = now.Add(ttl)
+		s.data[key] = entry
+	}
+
+	return result, nil
+}
+
+// * a subscriber that falls behind drops messages, same as a slow redis client
+func (s *MemoryStore) Publish(ctx context.Context, channel string, message string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, subscriber := range s.subscribers[channel] {
+		select {
+		case subscriber <- message:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	messages := make(chan string, 16)
+
+	s.mutex.Lock()
+	s.subscribers[channel] = append(s.subscribers[channel], messages)
+	s.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		subscribers := s.subscribers[channel]
+		for idx, subscriber := range subscribers {
+			if subscriber == messages {
+				s.subscribers[channel] = append(subscribers[:idx], subscribers[idx+1:]...)
+				break
+			}
+		}
+		close(messages)
+	}()
+
+	return messages, nil
+}
+
+func (s *MemoryStore) Pipeline() Pipeline {
+	return &memoryPipeline{
+		store: s,
+	}
+}
+
+// * glob with `*` and `?`, same as redis SCAN MATCH without character classes
+func matchPattern(pattern, str string) bool {
+	if pattern == "" {
+		return str == ""
+	}
+
+	switch pattern[0] {
+	case '*':
+		for idx := 0; idx <= len(str); idx++ {
+			if matchPattern(pattern[1:], str[idx:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return str != "" && matchPattern(pattern[1:], str[1:])
+	default:
+		return str != "" && pattern[0] == str[0] && matchPattern(pattern[1:], str[1:])
+	}
+}
+
+// * queued commands run under one lock, so Exec is atomic
+type memoryPipeline struct {
+	store   *MemoryStore
+	cmds    []func()
+	results []*StoreCmd
+}
+
+func (p *memoryPipeline) queue(fn func(cmd *StoreCmd)) *StoreCmd {
+	cmd := &StoreCmd{}
+	p.cmds = append(p.cmds, func() {
+		fn(cmd)
+	})
+	p.results = append(p.results, cmd)
+	return cmd
+}
+
+func (p *memoryPipeline) Get(ctx context.Context, key string) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val, cmd.err = p.store.get(key)
+	})
+}
+
+func (p *memoryPipeline) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		p.store.set(key, value, ttl)
+		cmd.val = "OK"
+	})
+}
+
+func (p *memoryPipeline) Del(ctx context.Context, keys ...string) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.del(keys...)
+	})
+}
+
+func (p *memoryPipeline) Incr(ctx context.Context, key string) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val, cmd.err = p.store.incr(key)
+	})
+}
+
+func (p *memoryPipeline) Expire(ctx context.Context, key string, ttl time.Duration) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.expire(key, ttl)
+	})
+}
+
+func (p *memoryPipeline) SAdd(ctx context.Context, key string, members ...interface{}) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.sadd(key, members...)
+	})
+}
+
+func (p *memoryPipeline) SCard(ctx context.Context, key string) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.scard(key)
+	})
+}
+
+func (p *memoryPipeline) LPush(ctx context.Context, key string, values ...interface{}) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.lpush(key, values...)
+	})
+}
+
+func (p *memoryPipeline) LTrim(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		p.store.ltrim(key, start, stop)
+		cmd.val = "OK"
+	})
+}
+
+func (p *memoryPipeline) LRange(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	return p.queue(func(cmd *StoreCmd) {
+		cmd.val = p.store.lrange(key, start, stop)
+	})
+}
+
+func (p *memoryPipeline) Exec(ctx context.Context) error {
+	p.store.mutex.Lock()
+	defer p.store.mutex.Unlock()
+
+	for _, fn := range p.cmds {
+		fn()
+	}
+
+	for _, cmd := range p.results {
+		if cmd.err != nil && cmd.err != ErrNil {
+			return cmd.err
+		}
+	}
+
+	return nil
+}
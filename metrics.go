@@ -0,0 +1,24 @@
+package golangIPSentry
+
+import "time"
+
+const (
+	GeoLookupCache = "cache" // * location found in store cache
+	GeoLookupHit   = "hit"   // * location found in GeoLite2 database
+	GeoLookupMiss  = "miss"  // * location not found
+)
+
+// * optional hook, see sub-package `metrics` for a Prometheus implementation
+type Metrics interface {
+	ObserveCheck(result IPGuardianResult)              // * decision and reason of each Check
+	ObserveScore(score *ScoreItem)                     // * final score and triggered flags
+	ObservePhase(phase string, duration time.Duration) // * store-bound phase latency, e.g. "device", "score_geo"
+	ObserveGeoLookup(result string)                    // * GeoLookupCache, GeoLookupHit or GeoLookupMiss
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveCheck(IPGuardianResult)      {}
+func (noopMetrics) ObserveScore(*ScoreItem)            {}
+func (noopMetrics) ObservePhase(string, time.Duration) {}
+func (noopMetrics) ObserveGeoLookup(string)            {}
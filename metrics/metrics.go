@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultNamespace = "ipsentry"
+	listRefresh      = 30 * time.Second // * list sizes are read from the managers at most once per interval
+)
+
+// * Prometheus implementation of golangIPSentry.Metrics
+type Collector struct {
+	checks   *prometheus.CounterVec
+	scores   prometheus.Histogram
+	flags    *prometheus.CounterVec
+	phases   *prometheus.HistogramVec
+	geo      *prometheus.CounterVec
+	lists    *prometheus.Desc
+	guardian *golangIPSentry.IPGuardian
+	sizes    map[string]int
+	sizedAt  time.Time
+	mutex    sync.Mutex
+}
+
+// * namespace default: "ipsentry"
+func New(namespace string) *Collector {
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	return &Collector{
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "checks_total",
+			Help:      "Checks by decision and reason.",
+		}, []string{"decision", "reason"}),
+		scores: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "score",
+			Help:      "Final risk score of scored requests.",
+			Buckets:   prometheus.LinearBuckets(0, 10, 11),
+		}),
+		flags: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "flags_total",
+			Help:      "Flags triggered by scorers.",
+		}, []string{"flag"}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "phase_duration_seconds",
+			Help:      "Store-bound latency of each check phase.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"phase"}),
+		geo: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "geo_lookups_total",
+			Help:      "GeoLite2 lookups by result (cache, hit, miss).",
+		}, []string{"result"}),
+		lists: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "list_size"),
+			"Entries in the allow, deny and block lists.",
+			[]string{"list"}, nil,
+		),
+	}
+}
+
+// * enable list size gauges, read from the managers at most once per 30s
+func (c *Collector) Attach(guardian *golangIPSentry.IPGuardian) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.guardian = guardian
+	c.sizes = nil
+}
+
+// * decision is "allow", "reject" or "monitor_reject" (monitor mode)
+func (c *Collector) ObserveCheck(result golangIPSentry.IPGuardianResult) {
+	decision := "allow"
+	switch {
+	case !result.Success:
+		decision = "reject"
+	case result.DryRun && result.Decision != nil && result.Decision.StatusCode != http.StatusOK:
+		decision = "monitor_reject"
+	}
+
+	c.checks.WithLabelValues(decision, result.Reason).Inc()
+}
+
+func (c *Collector) ObserveScore(score *golangIPSentry.ScoreItem) {
+	c.scores.Observe(float64(score.Score))
+
+	for _, flag := range score.Flag {
+		c.flags.WithLabelValues(flag).Inc()
+	}
+}
+
+func (c *Collector) ObservePhase(phase string, duration time.Duration) {
+	c.phases.WithLabelValues(phase).Observe(duration.Seconds())
+}
+
+func (c *Collector) ObserveGeoLookup(result string) {
+	c.geo.WithLabelValues(result).Inc()
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.checks.Describe(ch)
+	c.scores.Describe(ch)
+	c.flags.Describe(ch)
+	c.phases.Describe(ch)
+	c.geo.Describe(ch)
+	ch <- c.lists
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.checks.Collect(ch)
+	c.scores.Collect(ch)
+	c.flags.Collect(ch)
+	c.phases.Collect(ch)
+	c.geo.Collect(ch)
+
+	for name, total := range c.listSizes() {
+		ch <- prometheus.MustNewConstMetric(c.lists, prometheus.GaugeValue, float64(total), name)
+	}
+}
+
+// * List walks the Store, scrapes within listRefresh reuse the last sizes
+func (c *Collector) listSizes() map[string]int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.guardian == nil {
+		return nil
+	}
+	if c.sizes != nil && time.Since(c.sizedAt) < listRefresh {
+		return c.sizes
+	}
+
+	lists := map[string]func(page, size int) ([]golangIPSentry.IPItem, int, error){
+		"allow": c.guardian.Manager.Allow.List,
+		"deny":  c.guardian.Manager.Deny.List,
+		"block": c.guardian.Manager.Block.List,
+	}
+	sizes := make(map[string]int, len(lists))
+	for name, list := range lists {
+		_, total, err := list(1, 1)
+		if err != nil {
+			// * keep the last known size rather than dropping the series
+			if last, ok := c.sizes[name]; ok {
+				sizes[name] = last
+			}
+			continue
+		}
+		sizes[name] = total
+	}
+
+	c.sizes = sizes
+	c.sizedAt = time.Now()
+	return sizes
+}
+
+// * `/metrics` handler with its own registry
+func (c *Collector) Handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -1,25 +1,19 @@
 package golangIPSentry
 
 import (
-	"encoding/json"
 	"net/http"
-
-	"github.com/gin-gonic/gin"
 )
 
-func (i *IPGuardian) GinMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		check := i.Check(c.Request, c.Writer)
-		if !check.Success {
-			c.JSON(check.StatusCode, gin.H{
-				"error": check.Error,
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
+func (i *IPGuardian) errorHandler() ErrorHandler {
+	if i.Config.ErrorHandler != nil {
+		return i.Config.ErrorHandler
 	}
+	return DefaultErrorHandler
+}
+
+// * write a rejected result with Config.ErrorHandler, used by the adapter packages
+func (i *IPGuardian) WriteError(w http.ResponseWriter, r *http.Request, result IPGuardianResult) {
+	i.errorHandler()(w, r, result)
 }
 
 func (i *IPGuardian) HTTPMiddleware(next http.Handler) http.Handler {
@@ -27,11 +21,7 @@ func (i *IPGuardian) HTTPMiddleware(next http.Handler) http.Handler {
 		check := i.Check(r, w)
 
 		if !check.Success {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(check.StatusCode)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": check.Error,
-			})
+			i.WriteError(w, r, check)
 			return
 		}
 
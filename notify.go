@@ -0,0 +1,348 @@
+package golangIPSentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventBlock      = "block"      // * IP added to block list
+	EventBan        = "ban"        // * IP added to black list
+	EventEscalation = "escalation" // * blocked IP blocked again, block time increased
+	EventAttack     = "attack"     // * blocked IPs in a minute reached Parameter.AttackThreshold
+)
+
+type Event struct {
+	Type      string        `json:"type"`
+	IP        string        `json:"ip,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	Count     int           `json:"count,omitempty"`    // * block count, or blocked IPs in the minute for attack
+	Duration  time.Duration `json:"duration,omitempty"` // * block duration
+	Timestamp int64         `json:"timestamp"`
+}
+
+func (e Event) Message() string {
+	switch e.Type {
+	case EventBlock:
+		return fmt.Sprintf("[IP Sentry] IP %s has been blocked for %s", e.IP, e.Reason)
+	case EventBan:
+		return fmt.Sprintf("[IP Sentry] IP %s has been banned for %s", e.IP, e.Reason)
+	case EventEscalation:
+		return fmt.Sprintf("[IP Sentry] IP %s has been blocked %d times, blocked for %s", e.IP, e.Count, e.Duration)
+	case EventAttack:
+		return fmt.Sprintf("[IP Sentry] %d IPs have been blocked in the last minute", e.Count)
+	default:
+		return fmt.Sprintf("[IP Sentry] %s: %s %s", e.Type, e.IP, e.Reason)
+	}
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+type NotifierConfig struct {
+	Type   string   `json:"type"`   // * "webhook", "slack" or "discord"
+	URL    string   `json:"url"`    // * endpoint or incoming webhook URL
+	Secret string   `json:"secret"` // * webhook only, signs the body with HMAC-SHA256
+	Events []string `json:"events"` // * default: all events
+}
+
+type notifierEntry struct {
+	notifier Notifier
+	events   map[string]bool
+	queue    chan Event
+	dropped  atomic.Int64
+}
+
+type notifyDispatcher struct {
+	Logger  *Logger
+	Config  *Config
+	Context context.Context
+	cancel  context.CancelFunc
+	policy  *atomic.Pointer[policy]
+	entries []*notifierEntry
+	pending atomic.Int64 // * queued or in delivery, see Flush
+	mutex   sync.RWMutex
+	wg      sync.WaitGroup
+}
+
+func (i *IPGuardian) newNotifyDispatcher() (*notifyDispatcher, error) {
+	ctx, cancel := context.WithCancel(i.Context)
+	dispatcher := &notifyDispatcher{
+		Logger:  i.Logger,
+		Config:  i.Config,
+		Context: ctx,
+		cancel:  cancel,
+		policy:  &i.policy,
+	}
+
+	// * keep the original behavior, email is only sent on ban by default
+	if i.Config.Email != nil {
+		events := i.Config.Email.Events
+		if len(events) == 0 {
+			events = []string{EventBan}
+		}
+		switch i.Config.Email.TLS {
+		case "", EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+		default:
+			cancel()
+			return nil, fmt.Errorf("unknown email TLS mode %q", i.Config.Email.TLS)
+		}
+		dispatcher.add(NewSMTPNotifier(i.Config.Email), events)
+	}
+
+	for _, config := range i.Config.Notifiers {
+		var notifier Notifier
+		switch config.Type {
+		case "webhook":
+			notifier = NewWebhookNotifier(config.URL, config.Secret)
+		case "slack", "discord":
+			notifier = NewChatNotifier(config.Type, config.URL)
+		default:
+			cancel()
+			return nil, fmt.Errorf("unknown notifier type %q", config.Type)
+		}
+		dispatcher.add(notifier, config.Events)
+	}
+
+	return dispatcher, nil
+}
+
+// * events default to all events
+func (i *IPGuardian) RegisterNotifier(notifier Notifier, events ...string) error {
+	if notifier == nil {
+		return fmt.Errorf("notifier is nil")
+	}
+
+	i.notifier.add(notifier, events)
+
+	return nil
+}
+
+// * wait until queued notifications are delivered or given up, e.g. before Close in short-lived processes
+func (i *IPGuardian) Flush(timeout time.Duration) error {
+	if i.notifier == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := i.notifier.pending.Load()
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d notifications still pending after %s", pending, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// * one worker per notifier, a slow endpoint only delays its own queue
+func (d *notifyDispatcher) add(notifier Notifier, events []string) {
+	entry := &notifierEntry{
+		notifier: notifier,
+		queue:    make(chan Event, defaultNotifyQueue),
+	}
+	if len(events) > 0 {
+		entry.events = make(map[string]bool)
+		for _, event := range events {
+			entry.events[event] = true
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.entries = append(d.entries, entry)
+
+	d.wg.Add(1)
+	go d.run(entry)
+}
+
+// * deliver in background, failed deliveries are retried with backoff
+// * events are dropped when the queue of a notifier is full, e.g. during an attack
+func (d *notifyDispatcher) notify(event Event) {
+	if d == nil {
+		return
+	}
+
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UTC().Unix()
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for _, entry := range d.entries {
+		if entry.events != nil && !entry.events[event.Type] {
+			continue
+		}
+
+		d.pending.Add(1)
+		select {
+		case entry.queue <- event:
+		default:
+			d.pending.Add(-1)
+			entry.dropped.Add(1)
+		}
+	}
+}
+
+func (d *notifyDispatcher) run(entry *notifierEntry) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.Context.Done():
+			return
+		case event := <-entry.queue:
+			d.deliver(entry.notifier, event)
+			d.pending.Add(-1)
+		}
+
+		// * reported after the next delivery instead of per dropped event
+		if dropped := entry.dropped.Swap(0); dropped > 0 {
+			d.Logger.Warn(fmt.Sprintf("Dropped %d notifications, queue is full", dropped))
+		}
+	}
+}
+
+func (d *notifyDispatcher) deliver(notifier Notifier, event Event) {
+	p := d.policy.Load()
+	retry, backoff := p.NotifyRetry, p.NotifyBackoff
+
+	var err error
+	for attempt := 0; attempt <= retry; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-d.Context.Done():
+				return
+			}
+			// * exponential backoff
+			backoff *= 2
+		}
+
+		if err = notifier.Notify(d.Context, event); err == nil {
+			return
+		}
+	}
+
+	d.Logger.Error(err, "Failed to send "+event.Type+" notification")
+}
+
+// * stop pending retries and wait for deliveries in flight, queued events are dropped, see Flush
+func (d *notifyDispatcher) close() {
+	if d == nil {
+		return
+	}
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// * generic JSON webhook, body is the Event
+// * with secret, X-IPSentry-Signature is "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	HTTP   *http.Client
+}
+
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Secret: secret,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+		header.Set("X-IPSentry-Timestamp", timestamp)
+		header.Set("X-IPSentry-Signature", "sha256="+SignWebhook(n.Secret, timestamp, body))
+	}
+
+	return postJSON(ctx, n.HTTP, n.URL, header, body)
+}
+
+// * used by receivers to verify X-IPSentry-Signature
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// * Slack or Discord compatible incoming webhook
+type ChatNotifier struct {
+	Format string // * "slack" or "discord"
+	URL    string
+	HTTP   *http.Client
+}
+
+func NewChatNotifier(format, url string) *ChatNotifier {
+	return &ChatNotifier{
+		Format: format,
+		URL:    url,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *ChatNotifier) Notify(ctx context.Context, event Event) error {
+	field := "text"
+	if n.Format == "discord" {
+		field = "content"
+	}
+
+	body, err := json.Marshal(map[string]string{
+		field: event.Message(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, n.HTTP, n.URL, nil, body)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,379 @@
+package golangIPSentry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// * set a Parameter threshold or score to Disabled to turn it off, 0 uses the default
+// * rate limit tiers and durations have no Disabled, Validate rejects it
+const Disabled = -1
+
+const (
+	defaultBlockTimeMin  = 30 * time.Minute
+	defaultBlockTimeMax  = 30 * time.Hour
+	defaultNotifyBackoff = time.Second
+)
+
+type parameterDefault struct {
+	name     string // * JSON name, see Validate
+	value    *int
+	fallback int // * used for 0
+	disabled int // * used for Disabled
+}
+
+// * a disabled threshold stays Disabled and skips its check, a disabled score adds nothing
+func parameterDefaults(p *Parameter) []parameterDefault {
+	return []parameterDefault{
+		{"block_to_ban", &p.BlockToBan, 8, Disabled},
+		{"session_multi_ip", &p.SessionMultiIP, 4, Disabled},
+		{"ip_multi_device", &p.IPMultiDevice, 8, Disabled},
+		{"device_multi_ip", &p.DeviceMultiIP, 4, Disabled},
+		{"login_failure", &p.LoginFailure, 4, Disabled},
+		{"not_found_404", &p.NotFound404, 8, Disabled},
+		{"score_suspicious", &p.ScoreSuspicious, 50, Disabled},
+		{"score_dangerous", &p.ScoreDangerous, 80, Disabled},
+		{"score_session_multi_ip", &p.ScoreSessionMultiIP, 25, 0},
+		{"score_ip_multi_device", &p.ScoreIPMultiDevice, 20, 0},
+		{"score_device_multi_ip", &p.ScoreDeviceMultiIP, 15, 0},
+		{"score_fp_multi_session", &p.ScoreFpMultiSession, 50, 0},
+		{"score_geo_high_risk", &p.ScoreGeoHighRisk, 30, 0},
+		{"score_geo_hopping", &p.ScoreGeoHopping, 15, 0},
+		{"score_geo_frequent_switch", &p.ScoreGeoFrequentSwitch, 20, 0},
+		{"score_geo_rapid_change", &p.ScoreGeoRapidChange, 25, 0},
+		{"score_interval_request", &p.ScoreIntervalRequest, 25, 0},
+		{"score_frequency_request", &p.ScoreFrequencyRequest, 0, 0},
+		{"score_long_connection", &p.ScoreLongConnection, 15, 0},
+		{"score_login_failure", &p.ScoreLoginFailure, 15, 0},
+		{"score_not_found_404", &p.ScoreNotFound404, 15, 0},
+		{"score_abuseipdb", &p.ScoreAbuseIPDB, 50, 0},
+		{"attack_threshold", &p.AttackThreshold, 0, 0},
+		{"notify_retry", &p.NotifyRetry, 3, 0},
+	}
+}
+
+// * Parameter with every default applied, built once and never mutated
+// * requests load it once, so a check sees a single consistent version
+type policy struct {
+	Parameter
+	raw             Parameter // * as given, before defaults
+	version         int64     // * 0 for Config.Parameter
+	highRiskCountry map[string]bool
+}
+
+func resolvePolicy(p Parameter) (*policy, error) {
+	// * not shared with the caller's config
+	p.HighRiskCountry = append([]string(nil), p.HighRiskCountry...)
+	raw := p
+
+	if err := validRateLimitConfig(&p); err != nil {
+		return nil, err
+	}
+
+	for _, item := range parameterDefaults(&p) {
+		switch *item.value {
+		case 0:
+			*item.value = item.fallback
+		case Disabled:
+			*item.value = item.disabled
+		}
+	}
+
+	if p.BlockTimeMin <= 0 {
+		p.BlockTimeMin = defaultBlockTimeMin
+	}
+	if p.BlockTimeMax <= 0 {
+		p.BlockTimeMax = defaultBlockTimeMax
+	}
+	if p.BlockTimeMax < p.BlockTimeMin {
+		p.BlockTimeMax = p.BlockTimeMin
+	}
+	if p.NotifyBackoff <= 0 {
+		p.NotifyBackoff = defaultNotifyBackoff
+	}
+
+	highRiskCountry := make(map[string]bool, len(p.HighRiskCountry))
+	for _, country := range p.HighRiskCountry {
+		highRiskCountry[country] = true
+	}
+
+	return &policy{
+		Parameter:       p,
+		raw:             raw,
+		highRiskCountry: highRiskCountry,
+	}, nil
+}
+
+// * resolved Parameter with the set values of overrides, 0 keeps the value in use
+// * Disabled resolves like in resolvePolicy
+func (p *policy) override(overrides Parameter) Parameter {
+	parameter := p.Parameter
+	values := parameterDefaults(&parameter)
+	for idx, item := range parameterDefaults(&overrides) {
+		switch *item.value {
+		case 0:
+		case Disabled:
+			*values[idx].value = item.disabled
+		default:
+			*values[idx].value = *item.value
+		}
+	}
+	return parameter
+}
+
+// * Disabled thresholds are never reached
+func reached(score, threshold int) bool {
+	return threshold != Disabled && score >= threshold
+}
+
+// * copy of the resolved Parameter in use, disabled values are reported as Disabled
+// * can be modified and passed to UpdatePolicy
+func (i *IPGuardian) Policy() Parameter {
+	return i.policy.Load().view()
+}
+
+// * version of the policy in use, 0 until the first UpdatePolicy
+func (i *IPGuardian) PolicyVersion() int64 {
+	return i.policy.Load().version
+}
+
+func (p *policy) view() Parameter {
+	parameter, raw := p.Parameter, p.raw
+	parameter.HighRiskCountry = append([]string(nil), parameter.HighRiskCountry...)
+
+	raws := parameterDefaults(&raw)
+	for idx, item := range parameterDefaults(&parameter) {
+		if *raws[idx].value == Disabled {
+			*item.value = Disabled
+		}
+	}
+	return parameter
+}
+
+// * audit record of a policy change
+type PolicyUpdate struct {
+	Version   int64          `json:"version"`
+	Actor     string         `json:"actor"` // * who changed it, e.g. "admin:ops@example.com"
+	Time      time.Time      `json:"time"`
+	Changes   []PolicyChange `json:"changes"`
+	Parameter Parameter      `json:"parameter"` // * as given, defaults are resolved by each instance
+}
+
+type PolicyChange struct {
+	Field string `json:"field"` // * JSON name
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// * go-logger lines, title first
+func (u PolicyUpdate) messages(title string) []any {
+	messages := []any{
+		title,
+		fmt.Sprintf("version: %d", u.Version),
+		"actor: " + u.Actor,
+	}
+	for _, change := range u.Changes {
+		messages = append(messages, fmt.Sprintf("%s: %v -> %v", change.Field, change.From, change.To))
+	}
+	return messages
+}
+
+// * fields of the Policy view that differ
+func diffParameter(from, to Parameter) []PolicyChange {
+	changes := []PolicyChange{}
+
+	fromValue, toValue := reflect.ValueOf(from), reflect.ValueOf(to)
+	for idx := 0; idx < fromValue.NumField(); idx++ {
+		a, b := fromValue.Field(idx).Interface(), toValue.Field(idx).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		changes = append(changes, PolicyChange{
+			Field: jsonName(fromValue.Type().Field(idx)),
+			From:  a,
+			To:    b,
+		})
+	}
+
+	return changes
+}
+
+// * validate, then swap the policy of this instance and publish it to every instance sharing the Store
+// * in-flight checks keep the version they started with
+func (i *IPGuardian) UpdatePolicy(parameter Parameter, actor string) (*PolicyUpdate, error) {
+	if err := validateParameter(parameter); err != nil {
+		return nil, err
+	}
+
+	next, err := resolvePolicy(parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	// * shared counter, versions are ordered across instances
+	version, err := i.Store.Incr(i.Context, redisPolicyVersion)
+	if err != nil {
+		return nil, i.Logger.Error(err, "Failed to increase policy version")
+	}
+	next.version = version
+
+	update := PolicyUpdate{
+		Version:   version,
+		Actor:     actor,
+		Time:      time.Now().UTC(),
+		Changes:   diffParameter(i.policy.Load().view(), next.view()),
+		Parameter: next.raw,
+	}
+
+	data, err := json.Marshal(update)
+	if err != nil {
+		return nil, i.Logger.Error(err, "Failed to parse policy update")
+	}
+
+	// * concurrent updates may save out of order, the higher version wins
+	saved, err := i.Store.SetVersion(i.Context, redisPolicy, redisPolicyCurrent, version, data)
+	if err != nil {
+		return nil, i.Logger.Error(err, "Failed to save policy update")
+	}
+	if !saved {
+		i.syncPolicy()
+		return nil, fmt.Errorf("policy version %d is superseded by a newer update", version)
+	}
+
+	pipe := i.Store.Pipeline()
+	pipe.LPush(i.Context, redisPolicyHistory, data)
+	pipe.LTrim(i.Context, redisPolicyHistory, 0, defaultPolicyHistory-1)
+	if err := pipe.Exec(i.Context); err != nil {
+		return nil, i.Logger.Error(err, "Failed to save policy history")
+	}
+
+	i.swapPolicy(next)
+
+	if pubsub, ok := i.Store.(PubSub); ok {
+		if err := pubsub.Publish(i.Context, redisPolicyChannel, string(data)); err != nil {
+			// * other instances still pick it up on their next poll, see Config.PolicyInterval
+			i.Logger.WarnError(err, "Failed to publish policy update")
+		}
+	}
+
+	i.Logger.Info(update.messages("Policy updated")...)
+	i.audit.writePolicy(update)
+
+	return &update, nil
+}
+
+// * latest first, up to 100 updates
+func (i *IPGuardian) PolicyHistory() ([]PolicyUpdate, error) {
+	list, err := i.Store.LRange(i.Context, redisPolicyHistory, 0, -1)
+	if err != nil {
+		return nil, i.Logger.Error(err, "Failed to get policy history")
+	}
+
+	history := make([]PolicyUpdate, 0, len(list))
+	for _, data := range list {
+		var update PolicyUpdate
+		if err := json.Unmarshal([]byte(data), &update); err != nil {
+			continue
+		}
+		history = append(history, update)
+	}
+
+	return history, nil
+}
+
+// * an older version never replaces a newer one
+func (i *IPGuardian) swapPolicy(next *policy) bool {
+	for {
+		current := i.policy.Load()
+		if next.version <= current.version {
+			return false
+		}
+		if i.policy.CompareAndSwap(current, next) {
+			return true
+		}
+	}
+}
+
+// * apply an update from another instance
+func (i *IPGuardian) applyPolicy(data string) {
+	var update PolicyUpdate
+	if err := json.Unmarshal([]byte(data), &update); err != nil {
+		i.Logger.WarnError(err, "Failed to parse policy update")
+		return
+	}
+	if update.Version <= i.policy.Load().version {
+		return
+	}
+
+	if err := validateParameter(update.Parameter); err != nil {
+		i.Logger.WarnError(err, fmt.Sprintf("Failed to apply policy version %d", update.Version))
+		return
+	}
+	next, err := resolvePolicy(update.Parameter)
+	if err != nil {
+		i.Logger.WarnError(err, fmt.Sprintf("Failed to apply policy version %d", update.Version))
+		return
+	}
+	next.version = update.Version
+
+	if i.swapPolicy(next) {
+		i.Logger.Info(update.messages("Policy applied")...)
+	}
+}
+
+// * latest update in the Store, covers messages missed while disconnected
+func (i *IPGuardian) syncPolicy() {
+	data, err := i.Store.Get(i.Context, redisPolicy)
+	if err == ErrNil {
+		return
+	}
+	if err != nil {
+		i.Logger.WarnError(err, "Failed to get policy")
+		return
+	}
+	i.applyPolicy(data)
+}
+
+// * receive updates pushed by other instances until Close
+func (i *IPGuardian) subscribePolicy() {
+	pubsub, ok := i.Store.(PubSub)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(i.Context)
+	messages, err := pubsub.Subscribe(ctx, redisPolicyChannel)
+	if err != nil {
+		cancel()
+		i.Logger.WarnError(err, "Failed to subscribe policy updates")
+		return
+	}
+
+	i.policyCancel = cancel
+	i.policyDone = make(chan struct{})
+	go func() {
+		defer close(i.policyDone)
+		for data := range messages {
+			i.applyPolicy(data)
+		}
+	}()
+}
+
+// * poll the shared policy until Close, covers stores without PubSub
+func (i *IPGuardian) pollPolicy(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(i.pollDone)
+
+	for {
+		select {
+		case <-i.pollStop:
+			return
+		case <-ticker.C:
+			i.syncPolicy()
+		}
+	}
+}
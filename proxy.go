@@ -0,0 +1,147 @@
+package golangIPSentry
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// * default client IP headers, chains appended to by every proxy
+var forwardedHeaders = []string{
+	"X-Forwarded-For", // 標準反向代理
+	"Forwarded",       // 新標準
+}
+
+// * headers carrying a proxy chain, walked from right to left
+var forwardedChainHeaders = map[string]bool{
+	"X-Forwarded-For": true,
+	"X-Forwarded":     true,
+	"Forwarded-For":   true,
+	"Forwarded":       true,
+}
+
+func parseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+
+	for _, entry := range list {
+		_, parsed, err := parseIPEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		prefixes = append(prefixes, parsed...)
+	}
+
+	return prefixes, nil
+}
+
+func (i *IPGuardian) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range i.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// * forwarded headers are only honored when RemoteAddr is a trusted proxy
+func (i *IPGuardian) getClientIP(r Request) (string, bool, error) {
+	remote, err := parseHostIP(r.RemoteAddr())
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse client IP")
+	}
+
+	if !i.isTrustedProxy(remote) {
+		return remote.String(), isInternal(remote.String()), nil
+	}
+
+	// * single value headers, e.g. CF-Connecting-IP, are only read when configured
+	// * otherwise a client could set them and the proxy would pass them on
+	headers := forwardedHeaders
+	if i.Config.ClientIPHeader != "" {
+		headers = []string{http.CanonicalHeaderKey(i.Config.ClientIPHeader)}
+	}
+
+	for _, header := range headers {
+		value := strings.TrimSpace(r.Header(header))
+		if value == "" {
+			continue
+		}
+
+		var ip netip.Addr
+		var ok bool
+		if forwardedChainHeaders[header] {
+			ip, ok = i.walkForwarded(header, r.HeaderValues(header))
+		} else {
+			parsed, err := parseHostIP(value)
+			ip, ok = parsed, err == nil
+		}
+
+		if ok {
+			return ip.String(), isInternal(ip.String()), nil
+		}
+	}
+
+	return remote.String(), isInternal(remote.String()), nil
+}
+
+// * skip trusted hops from the right, the first untrusted hop is the client
+func (i *IPGuardian) walkForwarded(header string, values []string) (netip.Addr, bool) {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if header == "Forwarded" {
+				element = forwardedFor(element)
+			}
+			hops = append(hops, strings.TrimSpace(element))
+		}
+	}
+
+	var last netip.Addr
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		ip, err := parseHostIP(hops[idx])
+		// * unknown or obfuscated hop, the client can not be identified and the caller falls back
+		if err != nil {
+			return netip.Addr{}, false
+		}
+
+		last = ip
+		if !i.isTrustedProxy(ip) {
+			return ip, true
+		}
+	}
+
+	// * every hop is a trusted proxy, use the leftmost one
+	return last, last.IsValid()
+}
+
+// * RFC 7239: for=192.0.2.60;proto=http;by=203.0.113.43
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "for") {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return ""
+}
+
+// * accept "1.2.3.4", "1.2.3.4:80", "[2001:db8::1]:80" and "2001:db8::1"
+func parseHostIP(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,293 @@
+package golangIPSentry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	RateLimitSlidingLog     = "sliding_log"     // * exact, one entry per request in the window
+	RateLimitSlidingCounter = "sliding_counter" // * weighted count of the current and previous window
+	RateLimitGCRA           = "gcra"            // * token bucket, refills Limit per Window
+)
+
+type RateLimitResult struct {
+	Allowed    bool          `json:"allowed"`
+	Count      int           `json:"count"`       // * requests counted in the window
+	Limit      int           `json:"limit"`       // * Limit + Burst
+	Remaining  int           `json:"remaining"`   // * requests left before rejecting
+	RetryAfter time.Duration `json:"retry_after"` // * 0 when allowed
+	Reset      time.Duration `json:"reset"`       // * until the limit is fully restored
+	Window     time.Duration `json:"window"`
+}
+
+// * accept a plain number as requests per minute, window as nanoseconds or duration string
+func (r *RateLimit) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		var limit int
+		if err := json.Unmarshal(data, &limit); err != nil {
+			return fmt.Errorf("rate limit must be a number or an object: %w", err)
+		}
+		*r = RateLimit{
+			Limit:  limit,
+			Window: defaultRateLimitWindow,
+		}
+		return nil
+	}
+
+	var raw struct {
+		Limit  int             `json:"limit"`
+		Burst  int             `json:"burst"`
+		Window json.RawMessage `json:"window"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*r = RateLimit{
+		Limit: raw.Limit,
+		Burst: raw.Burst,
+	}
+
+	if len(raw.Window) == 0 || string(raw.Window) == "null" {
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw.Window, &str); err == nil {
+		window, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit window: %w", err)
+		}
+		r.Window = window
+		return nil
+	}
+
+	var ns int64
+	if err := json.Unmarshal(raw.Window, &ns); err != nil {
+		return fmt.Errorf("invalid rate limit window: %w", err)
+	}
+	r.Window = time.Duration(ns)
+
+	return nil
+}
+
+func validRateLimitConfig(p *Parameter) error {
+	switch p.RateLimitAlgorithm {
+	case "":
+		p.RateLimitAlgorithm = RateLimitSlidingCounter
+	case RateLimitSlidingLog, RateLimitSlidingCounter, RateLimitGCRA:
+	default:
+		return fmt.Errorf("unknown rate limit algorithm %q", p.RateLimitAlgorithm)
+	}
+
+	for _, tier := range []struct {
+		limit        *RateLimit
+		defaultLimit int
+	}{
+		{&p.RateLimitNormal, 100},
+		{&p.RateLimitSuspicious, 50},
+		{&p.RateLimitDangerous, 20},
+	} {
+		if tier.limit.Limit <= 0 {
+			tier.limit.Limit = tier.defaultLimit
+		}
+		if tier.limit.Burst < 0 {
+			tier.limit.Burst = 0
+		}
+		if tier.limit.Window <= 0 {
+			tier.limit.Window = defaultRateLimitWindow
+		}
+	}
+
+	return nil
+}
+
+// * consume false reads the current state without counting a request
+// * a route with its own limits has separate counters
+func (i *IPGuardian) rateLimit(p *policy, ip string, r *route, limit RateLimit, consume bool) (*RateLimitResult, error) {
+	return i.Store.RateLimit(i.Context, rateLimitKey(p, ip, r), p.RateLimitAlgorithm, limit, consume)
+}
+
+func rateLimitKey(p *policy, ip string, r *route) string {
+	if r != nil && r.ownLimit {
+		return fmt.Sprintf(redisRateLimitRoute, p.RateLimitAlgorithm, r.policy.Name, ip)
+	}
+	return fmt.Sprintf(redisRateLimit, p.RateLimitAlgorithm, ip)
+}
+
+func (r RateLimit) valid() error {
+	if r.Limit <= 0 || r.Burst < 0 || r.Window <= 0 {
+		return fmt.Errorf("invalid rate limit %d+%d per %s", r.Limit, r.Burst, r.Window)
+	}
+	return nil
+}
+
+// * sustained requests per second
+func (r RateLimit) rate() float64 {
+	return float64(r.Limit) / r.Window.Seconds()
+}
+
+func newRateLimitResult(limit RateLimit, allowed bool, count int, retryAfter, reset time.Duration) *RateLimitResult {
+	max := limit.Limit + limit.Burst
+	remaining := max - count
+	if remaining < 0 || !allowed {
+		remaining = 0
+	}
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+	if reset < 0 {
+		reset = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:    allowed,
+		Count:      count,
+		Limit:      max,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+		Reset:      reset,
+		Window:     limit.Window,
+	}
+}
+
+// * state of the in-memory limiter, same algorithms as the redis scripts
+type memoryRate struct {
+	log    []time.Time
+	counts map[int64]int
+	tat    time.Time
+}
+
+// * return the result and how long the state must be kept
+func (m *memoryRate) take(algorithm string, limit RateLimit, now time.Time, consume bool) (*RateLimitResult, time.Duration, error) {
+	if err := limit.valid(); err != nil {
+		return nil, 0, err
+	}
+
+	switch algorithm {
+	case RateLimitSlidingLog:
+		return m.slidingLog(limit, now, consume), limit.Window, nil
+	case RateLimitSlidingCounter:
+		return m.slidingCounter(limit, now, consume), 2 * limit.Window, nil
+	case RateLimitGCRA:
+		result := m.gcra(limit, now, consume)
+		return result, result.Reset, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+}
+
+func (m *memoryRate) slidingLog(limit RateLimit, now time.Time, consume bool) *RateLimitResult {
+	max := limit.Limit + limit.Burst
+	cutoff := now.Add(-limit.Window)
+
+	idx := 0
+	for idx < len(m.log) && !m.log[idx].After(cutoff) {
+		idx++
+	}
+	m.log = m.log[idx:]
+
+	count := len(m.log)
+	allowed := count < max
+	if allowed && consume {
+		m.log = append(m.log, now)
+		count++
+	}
+
+	var retryAfter, reset time.Duration
+	if !allowed {
+		// * wait until enough entries leave the window
+		retryAfter = m.log[count-max].Add(limit.Window).Sub(now)
+	}
+	if count > 0 {
+		reset = m.log[count-1].Add(limit.Window).Sub(now)
+	}
+
+	return newRateLimitResult(limit, allowed, count, retryAfter, reset)
+}
+
+func (m *memoryRate) slidingCounter(limit RateLimit, now time.Time, consume bool) *RateLimitResult {
+	max := float64(limit.Limit + limit.Burst)
+	window := limit.Window.Nanoseconds()
+	index := now.UnixNano() / window
+	elapsed := now.UnixNano() - index*window
+
+	if m.counts == nil {
+		m.counts = make(map[int64]int)
+	}
+	for key := range m.counts {
+		if key < index-1 {
+			delete(m.counts, key)
+		}
+	}
+
+	current := float64(m.counts[index])
+	previous := float64(m.counts[index-1])
+	estimate := previous*float64(window-elapsed)/float64(window) + current
+
+	allowed := estimate+1 <= max
+	if allowed && consume {
+		m.counts[index]++
+		current++
+		estimate++
+	}
+
+	retryAfter := time.Duration(slidingCounterRetry(max, current, previous, float64(window), float64(elapsed), allowed))
+
+	var reset time.Duration
+	switch {
+	case current > 0:
+		reset = time.Duration(2*window - elapsed)
+	case previous > 0:
+		reset = time.Duration(window - elapsed)
+	}
+
+	return newRateLimitResult(limit, allowed, int(math.Floor(estimate)), retryAfter, reset)
+}
+
+// * time until one more request fits, previous window weight decreases linearly
+func slidingCounterRetry(max, current, previous, window, elapsed float64, allowed bool) float64 {
+	if allowed {
+		return 0
+	}
+
+	if need := max - 1 - current; need >= 0 && previous > 0 {
+		return window*(1-need/previous) - elapsed
+	}
+
+	// * current window becomes the previous one
+	return window - elapsed + window*(1-(max-1)/current)
+}
+
+func (m *memoryRate) gcra(limit RateLimit, now time.Time, consume bool) *RateLimitResult {
+	interval := float64(limit.Window.Nanoseconds()) / float64(limit.Limit)
+	tolerance := interval * float64(limit.Limit+limit.Burst)
+
+	tat := m.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(interval))
+
+	used := float64(tat.Sub(now))
+	allowed := float64(newTat.Sub(now)) <= tolerance
+
+	var retryAfter time.Duration
+	if allowed {
+		if consume {
+			m.tat = newTat
+			used = float64(newTat.Sub(now))
+		}
+	} else {
+		retryAfter = time.Duration(float64(newTat.Sub(now)) - tolerance)
+	}
+
+	remaining := int(math.Floor((tolerance-used)/interval + 1e-9))
+
+	return newRateLimitResult(limit, allowed, limit.Limit+limit.Burst-remaining, retryAfter, time.Duration(used))
+}
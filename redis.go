@@ -0,0 +1,408 @@
+package golangIPSentry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisStore struct {
+	Client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		Client: client,
+	}
+}
+
+func redisError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return ErrNil
+	}
+	return err
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.Client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.Client.Close()
+}
+
+func (s *RedisStore) Publish(ctx context.Context, channel string, message string) error {
+	return s.Client.Publish(ctx, channel, message).Err()
+}
+
+// * go-redis reconnects the subscription, messages sent while disconnected are lost
+func (s *RedisStore) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	sub := s.Client.Subscribe(ctx, channel)
+	// * wait for the confirmation, later messages are not missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer sub.Close()
+
+		received := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-received:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.Client.Get(ctx, key).Result()
+	return value, redisError(err)
+}
+
+func (s *RedisStore) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values, err := s.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, len(values))
+	for idx, value := range values {
+		if str, ok := value.(string); ok {
+			list[idx] = str
+		}
+	}
+
+	return list, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return s.Client.Set(ctx, key, value, ttl).Err()
+}
+
+var redisSetVersionScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[2]) or '0')
+if tonumber(ARGV[1]) <= current then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+redis.call('SET', KEYS[2], ARGV[1])
+return 1
+`)
+
+func (s *RedisStore) SetVersion(ctx context.Context, key, versionKey string, version int64, value interface{}) (bool, error) {
+	ok, err := redisSetVersionScript.Run(ctx, s.Client, []string{key, versionKey}, version, value).Int()
+	return ok == 1, err
+}
+
+func (s *RedisStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	return s.Client.Del(ctx, keys...).Result()
+}
+
+func (s *RedisStore) Exists(ctx context.Context, key string) (bool, error) {
+	count, err := s.Client.Exists(ctx, key).Result()
+	return count > 0, err
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
+	return s.Client.Incr(ctx, key).Result()
+}
+
+func (s *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.Client.Expire(ctx, key, ttl).Err()
+}
+
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.Client.TTL(ctx, key).Result()
+}
+
+func (s *RedisStore) Scan(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+
+	iter := s.Client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	return keys, iter.Err()
+}
+
+func (s *RedisStore) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return s.Client.SAdd(ctx, key, members...).Err()
+}
+
+func (s *RedisStore) SCard(ctx context.Context, key string) (int64, error) {
+	return s.Client.SCard(ctx, key).Result()
+}
+
+func (s *RedisStore) LPush(ctx context.Context, key string, values ...interface{}) error {
+	return s.Client.LPush(ctx, key, values...).Err()
+}
+
+func (s *RedisStore) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return s.Client.LTrim(ctx, key, start, stop).Err()
+}
+
+func (s *RedisStore) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return s.Client.LRange(ctx, key, start, stop).Result()
+}
+
+// * TIME keeps instances on one clock, all values are in microseconds
+// * ARGV: window, limit, burst, consume, unique member
+// * return: allowed, count, retry after, reset
+var redisRateLimitScripts = map[string]*redis.Script{
+	RateLimitSlidingLog: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local max = tonumber(ARGV[2]) + tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = count < max
+if allowed and ARGV[4] == '1' then
+	redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[5])
+	redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+	count = count + 1
+end
+
+local retry = 0
+if not allowed then
+	local entry = redis.call('ZRANGE', KEYS[1], count - max, count - max, 'WITHSCORES')
+	retry = tonumber(entry[2]) + window - now
+end
+local reset = 0
+if count > 0 then
+	local entry = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	reset = tonumber(entry[2]) + window - now
+end
+
+return {allowed and 1 or 0, count, retry, reset}
+`),
+	RateLimitSlidingCounter: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local max = tonumber(ARGV[2]) + tonumber(ARGV[3])
+local index = math.floor(now / window)
+local elapsed = now - index * window
+
+local current = tonumber(redis.call('HGET', KEYS[1], index)) or 0
+local previous = tonumber(redis.call('HGET', KEYS[1], index - 1)) or 0
+local estimate = previous * (window - elapsed) / window + current
+
+local allowed = estimate + 1 <= max
+if allowed and ARGV[4] == '1' then
+	current = redis.call('HINCRBY', KEYS[1], index, 1)
+	estimate = estimate + 1
+	for _, field in ipairs(redis.call('HKEYS', KEYS[1])) do
+		if tonumber(field) < index - 1 then
+			redis.call('HDEL', KEYS[1], field)
+		end
+	end
+	redis.call('PEXPIRE', KEYS[1], math.ceil(2 * window / 1000))
+end
+
+local retry = 0
+if not allowed then
+	local need = max - 1 - current
+	if need >= 0 and previous > 0 then
+		retry = window * (1 - need / previous) - elapsed
+	else
+		retry = window - elapsed + window * (1 - (max - 1) / current)
+	end
+end
+local reset = 0
+if current > 0 then
+	reset = 2 * window - elapsed
+elseif previous > 0 then
+	reset = window - elapsed
+end
+
+return {allowed and 1 or 0, math.floor(estimate), retry, reset}
+`),
+	RateLimitGCRA: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local interval = window / limit
+local tolerance = interval * (limit + burst)
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+
+local used = tat - now
+local allowed = newTat - now <= tolerance
+local retry = 0
+if allowed then
+	if ARGV[4] == '1' then
+		used = newTat - now
+		redis.call('SET', KEYS[1], string.format('%.0f', newTat), 'PX', math.ceil(used / 1000))
+	end
+else
+	retry = newTat - now - tolerance
+end
+
+local remaining = math.floor((tolerance - used) / interval + 1e-9)
+
+return {allowed and 1 or 0, limit + burst - remaining, retry, used}
+`),
+}
+
+func (s *RedisStore) RateLimit(ctx context.Context, key string, algorithm string, limit RateLimit, consume bool) (*RateLimitResult, error) {
+	if err := limit.valid(); err != nil {
+		return nil, err
+	}
+
+	script, ok := redisRateLimitScripts[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+
+	flag := 0
+	if consume {
+		flag = 1
+	}
+
+	// * sorted set members must be unique within the same microsecond
+	member := make([]byte, 8)
+	rand.Read(member)
+
+	values, err := script.Run(ctx, s.Client, []string{key},
+		limit.Window.Microseconds(),
+		limit.Limit,
+		limit.Burst,
+		flag,
+		hex.EncodeToString(member),
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", values)
+	}
+
+	return newRateLimitResult(limit,
+		values[0] == 1,
+		int(values[1]),
+		time.Duration(values[2])*time.Microsecond,
+		time.Duration(values[3])*time.Microsecond,
+	), nil
+}
+
+func (s *RedisStore) Pipeline() Pipeline {
+	return &redisPipeline{
+		pipe: s.Client.TxPipeline(),
+	}
+}
+
+type redisPipeline struct {
+	pipe  redis.Pipeliner
+	after []func()
+}
+
+func (p *redisPipeline) queue(cmd redis.Cmder, value func() interface{}) *StoreCmd {
+	result := &StoreCmd{}
+	p.after = append(p.after, func() {
+		result.err = redisError(cmd.Err())
+		if result.err == nil {
+			result.val = value()
+		}
+	})
+	return result
+}
+
+func (p *redisPipeline) Get(ctx context.Context, key string) *StoreCmd {
+	cmd := p.pipe.Get(ctx, key)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *StoreCmd {
+	cmd := p.pipe.Set(ctx, key, value, ttl)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) Del(ctx context.Context, keys ...string) *StoreCmd {
+	cmd := p.pipe.Del(ctx, keys...)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) Incr(ctx context.Context, key string) *StoreCmd {
+	cmd := p.pipe.Incr(ctx, key)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) Expire(ctx context.Context, key string, ttl time.Duration) *StoreCmd {
+	cmd := p.pipe.Expire(ctx, key, ttl)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) SAdd(ctx context.Context, key string, members ...interface{}) *StoreCmd {
+	cmd := p.pipe.SAdd(ctx, key, members...)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) SCard(ctx context.Context, key string) *StoreCmd {
+	cmd := p.pipe.SCard(ctx, key)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) LPush(ctx context.Context, key string, values ...interface{}) *StoreCmd {
+	cmd := p.pipe.LPush(ctx, key, values...)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) LTrim(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	cmd := p.pipe.LTrim(ctx, key, start, stop)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) LRange(ctx context.Context, key string, start, stop int64) *StoreCmd {
+	cmd := p.pipe.LRange(ctx, key, start, stop)
+	return p.queue(cmd, func() interface{} { return cmd.Val() })
+}
+
+func (p *redisPipeline) Exec(ctx context.Context) error {
+	cmds, err := p.pipe.Exec(ctx)
+
+	for _, fn := range p.after {
+		fn()
+	}
+
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+	}
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	return nil
+}
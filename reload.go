@@ -0,0 +1,153 @@
+package golangIPSentry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// * last version of a list file seen by the manager
+type listFile struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func newListFile(info os.FileInfo, data []byte) listFile {
+	return listFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    sha256.Sum256(data),
+	}
+}
+
+// * changed return false when the file is missing or has the same content
+// * a file failing to validate is remembered, the error is reported once
+func (f *listFile) read(path string) (list []IPItem, changed bool, err error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil, false, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	state := newListFile(info, data)
+	if state.hash == f.hash {
+		*f = state
+		return nil, false, nil
+	}
+	*f = state
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, false, err
+	}
+
+	// * all or nothing, one invalid entry rejects the whole version
+	for idx := range list {
+		entry, _, err := parseIPEntry(list[idx].IP)
+		if err != nil {
+			return nil, false, fmt.Errorf("entry %d: %w", idx, err)
+		}
+		list[idx].IP = entry
+	}
+
+	return list, true, nil
+}
+
+type listDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (d listDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// * go-logger lines, title first
+func (d listDiff) messages(title string) []any {
+	messages := []any{title}
+	for _, part := range []struct {
+		name    string
+		entries []string
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"changed", d.Changed},
+	} {
+		if len(part.entries) > 0 {
+			messages = append(messages, part.name+": "+strings.Join(part.entries, ", "))
+		}
+	}
+	return messages
+}
+
+// * return the cache of the new list, entries without added_at keep the current one
+func diffIPItems(cache map[string]*IPItem, list []IPItem) (map[string]*IPItem, listDiff) {
+	var diff listDiff
+	now := time.Now().UTC().Unix()
+	next := make(map[string]*IPItem, len(list))
+
+	for _, item := range list {
+		current, exist := cache[item.IP]
+		if item.AddedAt == 0 {
+			item.AddedAt = now
+			if exist {
+				item.AddedAt = current.AddedAt
+			}
+		}
+		copied := item
+		next[item.IP] = &copied
+	}
+
+	for entry, item := range next {
+		current, exist := cache[entry]
+		switch {
+		case !exist:
+			diff.Added = append(diff.Added, entry)
+		case *current != *item:
+			diff.Changed = append(diff.Changed, entry)
+		}
+	}
+	for entry := range cache {
+		if _, exist := next[entry]; !exist {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	return next, diff
+}
+
+// * poll the list files until Close
+func (i *IPGuardian) watchLists(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(i.watchDone)
+
+	for {
+		select {
+		case <-i.watchStop:
+			return
+		case <-ticker.C:
+			i.Manager.Allow.reload()
+			i.Manager.Deny.reload()
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package golangIPSentry
+
+import (
+	"net/http"
+)
+
+// * framework independent view of a request and its response headers
+// * net/http is covered by NewHTTPRequest, other frameworks by the adapter packages
+type Request interface {
+	Method() string
+	Path() string
+	RemoteAddr() string               // * "host:port" of the connection
+	Header(key string) string         // * first value, "" when missing
+	HeaderValues(key string) []string // * every value of a repeated header
+	Cookie(name string) string        // * "" when missing
+	SetHeader(key, value string)      // * response header
+	SetCookie(cookie Cookie)          // * response cookie
+}
+
+// * cookies set by Check, always HttpOnly
+type Cookie struct {
+	Name     string
+	Value    string
+	Path     string
+	MaxAge   int // * seconds
+	Secure   bool
+	SameSite string // * "Strict", "Lax" or "None"
+}
+
+var httpSameSite = map[string]http.SameSite{
+	"Strict": http.SameSiteStrictMode,
+	"Lax":    http.SameSiteLaxMode,
+	"None":   http.SameSiteNoneMode,
+}
+
+type httpRequest struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+// * w may be nil, response headers and cookies are then dropped
+func NewHTTPRequest(w http.ResponseWriter, r *http.Request) Request {
+	return &httpRequest{
+		w: w,
+		r: r,
+	}
+}
+
+func (h *httpRequest) Method() string {
+	return h.r.Method
+}
+
+func (h *httpRequest) Path() string {
+	return h.r.URL.Path
+}
+
+func (h *httpRequest) RemoteAddr() string {
+	return h.r.RemoteAddr
+}
+
+func (h *httpRequest) Header(key string) string {
+	return h.r.Header.Get(key)
+}
+
+func (h *httpRequest) HeaderValues(key string) []string {
+	return h.r.Header.Values(key)
+}
+
+func (h *httpRequest) Cookie(name string) string {
+	cookie, err := h.r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (h *httpRequest) SetHeader(key, value string) {
+	if h.w != nil {
+		h.w.Header().Set(key, value)
+	}
+}
+
+func (h *httpRequest) SetCookie(cookie Cookie) {
+	if h.w == nil {
+		return
+	}
+
+	http.SetCookie(h.w, &http.Cookie{
+		Name:     cookie.Name,
+		Value:    cookie.Value,
+		Path:     cookie.Path,
+		MaxAge:   cookie.MaxAge,
+		HttpOnly: true,
+		Secure:   cookie.Secure,
+		SameSite: httpSameSite[cookie.SameSite],
+	})
+}
@@ -0,0 +1,182 @@
+package golangIPSentry
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// * writes the response of a rejected request, headers from Check are already set
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, result IPGuardianResult)
+
+// * RFC 7807 body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+const defaultBlockPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Detail}}</p>
+</body>
+</html>
+`
+
+var defaultBlockTemplate = template.Must(template.New("block").Parse(defaultBlockPage))
+
+// * client facing message, the IP and internal details are not exposed
+func PublicError(result IPGuardianResult) string {
+	switch result.Reason {
+	case ReasonBlockList, ReasonScoreBlock:
+		return "Access is temporarily blocked"
+	case ReasonDenyList, ReasonBlockToBan:
+		return "Access is denied"
+	case ReasonRateNormal, ReasonRateSuspicious, ReasonRateDangerous, ReasonFailLocal:
+		return "Too many requests, please retry later"
+	case ReasonFailClosed:
+		return "Service is temporarily unavailable"
+	case ReasonDeviceError, ReasonCheckError:
+		return "Unable to process the request"
+	}
+
+	return redactIP(result.Error)
+}
+
+// * messages end with ", IP: x.x.x.x", other addresses are masked
+func redactIP(message string) string {
+	message, _, _ = strings.Cut(message, ", IP: ")
+
+	fields := strings.Fields(message)
+	for idx, field := range fields {
+		trimmed := strings.Trim(field, ".,;:()[]\"'")
+		if _, err := netip.ParseAddr(trimmed); err == nil {
+			fields[idx] = strings.Replace(field, trimmed, "[redacted]", 1)
+		}
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func newProblem(path string, result IPGuardianResult) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(result.StatusCode),
+		Status:   result.StatusCode,
+		Detail:   PublicError(result),
+		Instance: path,
+		Reason:   result.Reason,
+	}
+}
+
+// * application/problem+json
+func ProblemErrorHandler(w http.ResponseWriter, r *http.Request, result IPGuardianResult) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(result.StatusCode)
+	json.NewEncoder(w).Encode(newProblem(r.URL.Path, result))
+}
+
+// * block page rendered with Problem, nil uses the built-in page
+func HTMLErrorHandler(tmpl *template.Template) ErrorHandler {
+	if tmpl == nil {
+		tmpl = defaultBlockTemplate
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, result IPGuardianResult) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(result.StatusCode)
+		tmpl.Execute(w, newProblem(r.URL.Path, result))
+	}
+}
+
+// * status text only
+func MinimalErrorHandler(w http.ResponseWriter, r *http.Request, result IPGuardianResult) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(result.StatusCode)
+	w.Write([]byte(http.StatusText(result.StatusCode) + "\n"))
+}
+
+// * pick problem+json, HTML or plain text by the Accept header
+func NegotiateErrorHandler(problem, html, minimal ErrorHandler) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, result IPGuardianResult) {
+		switch negotiate(r.Header.Get("Accept"), "application/problem+json", "application/json", "text/html", "text/plain") {
+		case "text/html":
+			html(w, r, result)
+		case "text/plain":
+			minimal(w, r, result)
+		default:
+			problem(w, r, result)
+		}
+	}
+}
+
+// * default of Config.ErrorHandler
+var DefaultErrorHandler = NegotiateErrorHandler(ProblemErrorHandler, HTMLErrorHandler(nil), MinimalErrorHandler)
+
+// * return the offer with the highest quality, the first offer when nothing matches
+func negotiate(accept string, offers ...string) string {
+	best, bestQuality, bestSpecific := offers[0], -1.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		for _, offer := range offers {
+			specific := mediaSpecificity(mediaType, offer)
+			if specific < 0 {
+				continue
+			}
+			// * higher quality first, then the more specific range
+			if quality > bestQuality || (quality == bestQuality && specific > bestSpecific) {
+				best, bestQuality, bestSpecific = offer, quality, specific
+			}
+			break
+		}
+	}
+
+	return best
+}
+
+// * 2 for exact, 1 for type/*, 0 for */*, -1 when not matched
+func mediaSpecificity(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,194 @@
+package golangIPSentry
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// * overrides Parameter for matching requests, the first matching route applies
+// * exactly one of Path, Glob or Regex must be set
+type RoutePolicy struct {
+	Name                string     `json:"name"`                  // * default: the pattern, also separates rate limit counters
+	Methods             []string   `json:"methods"`               // * default: any method
+	Path                string     `json:"path"`                  // * path prefix, e.g. "/api/"
+	Glob                string     `json:"glob"`                  // * path.Match pattern, e.g. "/static/*.js"
+	Regex               string     `json:"regex"`                 // * e.g. "^/users/[0-9]+/login$"
+	Skip                bool       `json:"skip"`                  // * bypass every check, e.g. health checks
+	RateLimitNormal     *RateLimit `json:"rate_limit_normal"`     // * default: Parameter.RateLimitNormal
+	RateLimitSuspicious *RateLimit `json:"rate_limit_suspicious"` // * default: Parameter.RateLimitSuspicious
+	RateLimitDangerous  *RateLimit `json:"rate_limit_dangerous"`  // * default: Parameter.RateLimitDangerous
+	ScoreSuspicious     int        `json:"score_suspicious"`      // * default: Parameter.ScoreSuspicious, Disabled turns it off
+	ScoreDangerous      int        `json:"score_dangerous"`       // * default: Parameter.ScoreDangerous, Disabled turns it off
+	Scorers             []string   `json:"scorers"`               // * enabled built-in and custom scorers, default: all
+}
+
+type route struct {
+	policy  RoutePolicy
+	methods map[string]bool
+	regex   *regexp.Regexp
+	scorers map[string]bool
+	// * route has its own rate limit counters
+	ownLimit bool
+	// * set values replace the policy in use, see policy.override
+	overrides Parameter
+}
+
+func compileRoutes(policies []RoutePolicy) ([]*route, error) {
+	routes := make([]*route, 0, len(policies))
+	names := make(map[string]bool)
+
+	for idx, policy := range policies {
+		patterns := 0
+		for _, pattern := range []string{policy.Path, policy.Glob, policy.Regex} {
+			if pattern != "" {
+				patterns++
+				if policy.Name == "" {
+					policy.Name = pattern
+				}
+			}
+		}
+		if patterns != 1 {
+			return nil, fmt.Errorf("route %d must set exactly one of path, glob or regex", idx)
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("route %s is duplicated", policy.Name)
+		}
+		names[policy.Name] = true
+
+		item := &route{
+			policy: policy,
+			overrides: Parameter{
+				ScoreSuspicious: policy.ScoreSuspicious,
+				ScoreDangerous:  policy.ScoreDangerous,
+			},
+		}
+		for _, override := range parameterDefaults(&item.overrides) {
+			if *override.value < 0 && *override.value != Disabled {
+				return nil, fmt.Errorf("route %s: %s must not be negative", policy.Name, override.name)
+			}
+		}
+
+		if policy.Glob != "" {
+			if _, err := path.Match(policy.Glob, "/"); err != nil {
+				return nil, fmt.Errorf("route %s: invalid glob: %w", policy.Name, err)
+			}
+		}
+		if policy.Regex != "" {
+			regex, err := regexp.Compile(policy.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: invalid regex: %w", policy.Name, err)
+			}
+			item.regex = regex
+		}
+
+		if len(policy.Methods) > 0 {
+			item.methods = make(map[string]bool)
+			for _, method := range policy.Methods {
+				item.methods[strings.ToUpper(method)] = true
+			}
+		}
+
+		if len(policy.Scorers) > 0 {
+			item.scorers = make(map[string]bool)
+			for _, name := range policy.Scorers {
+				item.scorers[name] = true
+			}
+		}
+
+		// * copied, the config may be shared
+		for _, limit := range []**RateLimit{&item.policy.RateLimitNormal, &item.policy.RateLimitSuspicious, &item.policy.RateLimitDangerous} {
+			if *limit == nil {
+				continue
+			}
+			copied := **limit
+			if copied.Limit == Disabled {
+				return nil, fmt.Errorf("route %s: Disabled is not supported by rate limit tiers, use skip to bypass them", policy.Name)
+			}
+			if copied.Window <= 0 {
+				copied.Window = defaultRateLimitWindow
+			}
+			if err := copied.valid(); err != nil {
+				return nil, fmt.Errorf("route %s: %w", policy.Name, err)
+			}
+			*limit = &copied
+			item.ownLimit = true
+		}
+
+		routes = append(routes, item)
+	}
+
+	return routes, nil
+}
+
+func (r *route) match(req Request) bool {
+	if r.methods != nil && !r.methods[req.Method()] {
+		return false
+	}
+
+	urlPath := req.Path()
+	switch {
+	case r.policy.Path != "":
+		return strings.HasPrefix(urlPath, r.policy.Path)
+	case r.policy.Glob != "":
+		matched, _ := path.Match(r.policy.Glob, urlPath)
+		return matched
+	default:
+		return r.regex.MatchString(urlPath)
+	}
+}
+
+// * nil when no route matches
+func (i *IPGuardian) matchRoute(req Request) *route {
+	for _, item := range i.routes {
+		if item.match(req) {
+			return item
+		}
+	}
+	return nil
+}
+
+// * a nil route enables every scorer
+func (r *route) scorerEnabled(name string) bool {
+	return r == nil || r.scorers == nil || r.scorers[name]
+}
+
+// * compare with reached, a Disabled threshold is never reached
+func scoreThresholds(p *policy, r *route) (suspicious, dangerous int) {
+	if r == nil {
+		return p.ScoreSuspicious, p.ScoreDangerous
+	}
+
+	parameter := p.override(r.overrides)
+	return parameter.ScoreSuspicious, parameter.ScoreDangerous
+}
+
+// * tier limit of the route, falls back to Parameter
+func routeRateLimit(p *policy, r *route, score *ScoreItem) (limit RateLimit, reason, level string) {
+	parameter := p.Parameter
+	var policy RoutePolicy
+	if r != nil {
+		policy = r.policy
+	}
+
+	switch {
+	case score.IsDangerous:
+		return routeTier(policy.RateLimitDangerous, policy.RateLimitNormal, parameter.RateLimitDangerous), ReasonRateDangerous, "Dangerous"
+	case score.IsSuspicious:
+		return routeTier(policy.RateLimitSuspicious, policy.RateLimitNormal, parameter.RateLimitSuspicious), ReasonRateSuspicious, "Suspicious"
+	default:
+		return routeTier(policy.RateLimitNormal, nil, parameter.RateLimitNormal), ReasonRateNormal, "Normal"
+	}
+}
+
+// * an unset tier is never looser than the normal limit of the route
+func routeTier(override, normal *RateLimit, limit RateLimit) RateLimit {
+	if override != nil {
+		return *override
+	}
+	if normal != nil && normal.rate() < limit.rate() {
+		return *normal
+	}
+	return limit
+}
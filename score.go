@@ -2,27 +2,25 @@
 package golangIPSentry
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
 type ScoreItem struct {
-	IsBlock      bool
-	IsSuspicious bool
-	IsDangerous  bool
-	Flag         []string
-	Score        int
-	Detail       map[string]interface{}
-	Timestamp    int64
+	IsBlock      bool                   `json:"is_block"`
+	IsSuspicious bool                   `json:"is_suspicious"`
+	IsDangerous  bool                   `json:"is_dangerous"`
+	Flag         []string               `json:"flag"`
+	Score        int                    `json:"score"`
+	Detail       map[string]interface{} `json:"detail"`
+	Timestamp    int64                  `json:"timestamp"`
 }
 
 type RiskScore struct {
@@ -30,269 +28,109 @@ type RiskScore struct {
 	Detail map[string]interface{}
 }
 
-// func (i *IPGuardian) dynamicScore(device *Device) (*ScoreItem, error) {
-// 	var list []string
-// 	score := RiskScore{
-// 		Base:   0,
-// 		Detail: make(map[string]interface{}),
-// 	}
-
-// 	if err := i.calcBasic(device, &list, &score); err != nil {
-// 		return nil, err
-// 	}
-
-// 	if err := i.calcGeo(device, &list, &score); err != nil {
-// 		return nil, err
-// 	}
-
-// 	if err := i.calcBehavior(device, &list, &score); err != nil {
-// 		return nil, err
-// 	}
-
-// 	if err := i.calcFingerprint(device, &list, &score); err != nil {
-// 		return nil, err
-// 	}
-
-// 	totalRisk := i.calcScore(score)
-
-// 	if totalRisk > 100 {
-// 		i.Manager.Block.Add(device.IP.Address, "Score greater than 100")
-// 	}
-
-// 	print(totalRisk)
-
-// 	return &ScoreItem{
-// 		IsBlock:      totalRisk >= 100,
-// 		IsSuspicious: totalRisk >= i.Config.Parameter.ScoreSuspicious,
-// 		IsDangerous:  totalRisk >= i.Config.Parameter.ScoreDangerous,
-// 		Flag:         list,
-// 		Score:        totalRisk,
-// 		Detail:       score.Detail,
-// 	}, nil
-// }
-
-func (i *IPGuardian) dynamicScore(device *Device) (*ScoreItem, error) {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
+// * route selects scorers and thresholds, nil uses Parameter
+func (i *IPGuardian) dynamicScore(p *policy, device *Device, route *route) (*ScoreItem, error) {
 	var combinedFlags []string
 	combinedScore := RiskScore{
 		Base:   0,
 		Detail: make(map[string]interface{}),
 	}
 
-	errChan := make(chan error, 4)
-
-	if i.Config.Parameter.ScoreSuspicious <= 0 {
-		i.Config.Parameter.ScoreSuspicious = 50
-	}
-	if i.Config.Parameter.ScoreDangerous <= 0 {
-		i.Config.Parameter.ScoreDangerous = 80
-	}
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var localFlags []string
-		localScore := RiskScore{
-			Base:   0,
-			Detail: make(map[string]interface{}),
-		}
-
-		if err := i.calcBasic(device, &localFlags, &localScore); err != nil {
-			errChan <- err
-			return
-		}
-
-		mu.Lock()
-		combinedFlags = append(combinedFlags, localFlags...)
-		combinedScore.Base += localScore.Base
-		for k, v := range localScore.Detail {
-			combinedScore.Detail[k] = v
-		}
-		mu.Unlock()
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var localFlags []string
-		localScore := RiskScore{
-			Base:   0,
-			Detail: make(map[string]interface{}),
+	tasks := []ScoreTask{}
+	for _, task := range i.scoreTasks(p) {
+		if route.scorerEnabled(task.Name) {
+			tasks = append(tasks, task)
 		}
+	}
+	resultChan := make(chan ScoreResult, len(tasks))
 
-		if err := i.calcGeo(device, &localFlags, &localScore); err != nil {
-			errChan <- err
-			return
-		}
+	for _, task := range tasks {
+		go func(t ScoreTask) {
+			start := time.Now()
+			result := i.runScoreTask(t, device)
+			i.Metrics.ObservePhase("score_"+t.Name, time.Since(start))
+			result.Custom = t.Custom
+			resultChan <- result
+		}(task)
+	}
 
-		mu.Lock()
-		combinedFlags = append(combinedFlags, localFlags...)
-		combinedScore.Base += localScore.Base
-		for k, v := range localScore.Detail {
-			combinedScore.Detail[k] = v
-		}
-		mu.Unlock()
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var localFlags []string
-		localScore := RiskScore{
-			Base:   0,
-			Detail: make(map[string]interface{}),
-		}
+	var firstErr error
+	for n := 0; n < len(tasks); n++ {
+		result := <-resultChan
 
-		if err := i.calcBehavior(device, &localFlags, &localScore); err != nil {
-			errChan <- err
-			return
+		if result.Error != nil {
+			if result.Custom {
+				// * custom scorer is optional, do not fail the whole score
+				i.Logger.WarnError(result.Error, "Failed to run scorer "+result.Name)
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error in %s calculation: %w", result.Name, result.Error)
+			}
+			continue
 		}
 
-		mu.Lock()
-		combinedFlags = append(combinedFlags, localFlags...)
-		combinedScore.Base += localScore.Base
-		for k, v := range localScore.Detail {
-			combinedScore.Detail[k] = v
-		}
-		mu.Unlock()
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var localFlags []string
-		localScore := RiskScore{
-			Base:   0,
-			Detail: make(map[string]interface{}),
-		}
+		combinedFlags = append(combinedFlags, result.Flags...)
+		combinedScore.Base += result.Score.Base
 
-		if err := i.calcFingerprint(device, &localFlags, &localScore); err != nil {
-			errChan <- err
-			return
+		if result.Custom {
+			// * namespaced to avoid overwriting built-in details
+			if len(result.Score.Detail) > 0 {
+				combinedScore.Detail[result.Name] = result.Score.Detail
+			}
+			continue
 		}
-
-		mu.Lock()
-		combinedFlags = append(combinedFlags, localFlags...)
-		combinedScore.Base += localScore.Base
-		for k, v := range localScore.Detail {
+		for k, v := range result.Score.Detail {
 			combinedScore.Detail[k] = v
 		}
-		mu.Unlock()
-	}()
-
-	wg.Wait()
-	close(errChan)
+	}
 
-	for err := range errChan {
-		if err != nil {
-			return nil, err
-		}
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
 	totalRisk := i.calcScore(combinedScore)
+	suspicious, dangerous := scoreThresholds(p, route)
 
-	if totalRisk > 100 {
+	if totalRisk > 100 && !i.isMonitor() {
 		i.Manager.Block.Add(device.IP.Address, "Score greater than 100")
 	}
 
-	return &ScoreItem{
+	item := &ScoreItem{
 		IsBlock:      totalRisk >= 100,
-		IsSuspicious: totalRisk >= i.Config.Parameter.ScoreSuspicious,
-		IsDangerous:  totalRisk >= i.Config.Parameter.ScoreDangerous,
+		IsSuspicious: reached(totalRisk, suspicious),
+		IsDangerous:  reached(totalRisk, dangerous),
 		Flag:         combinedFlags,
 		Score:        totalRisk,
 		Detail:       combinedScore.Detail,
-	}, nil
+		Timestamp:    time.Now().UTC().Unix(),
+	}
+
+	i.Metrics.ObserveScore(item)
+
+	// * kept for Inspect
+	if data, err := json.Marshal(item); err == nil {
+		i.Store.Set(i.Context, fmt.Sprintf(redisScoreLast, device.IP.Address), data, 24*time.Hour)
+	}
+
+	return item, nil
 }
 
-// 更進階的並行優化 - 使用工作池模式
 type ScoreTask struct {
-	Name string
-	Func func(*Device, *[]string, *RiskScore) error
+	Name    string
+	Func    func(context.Context, *Device, *[]string, *RiskScore) error
+	Timeout time.Duration // * <= 0 uses default 500ms
+	Custom  bool          // * registered by RegisterScorer
 }
 
 type ScoreResult struct {
-	Name  string
-	Flags []string
-	Score RiskScore
-	Error error
+	Name   string
+	Flags  []string
+	Score  RiskScore
+	Error  error
+	Custom bool
 }
 
-// func (i *IPGuardian) dynamicScoreAdvanced(device *Device) (*ScoreItem, error) {
-// 	// 定義計算任務
-// 	tasks := []ScoreTask{
-// 		{"basic", i.calcBasic},
-// 		{"geo", i.calcGeo},
-// 		{"behavior", i.calcBehavior},
-// 		{"fingerprint", i.calcFingerprint},
-// 	}
-
-// 	// 結果通道
-// 	resultChan := make(chan ScoreResult, len(tasks))
-
-// 	// 並行執行所有任務
-// 	for _, task := range tasks {
-// 		go func(t ScoreTask) {
-// 			var flags []string
-// 			score := RiskScore{
-// 				Base:   0,
-// 				Detail: make(map[string]interface{}),
-// 			}
-
-// 			err := t.Func(device, &flags, &score)
-
-// 			resultChan <- ScoreResult{
-// 				Name:  t.Name,
-// 				Flags: flags,
-// 				Score: score,
-// 				Error: err,
-// 			}
-// 		}(task)
-// 	}
-
-// 	// 收集所有結果
-// 	var combinedFlags []string
-// 	combinedScore := RiskScore{
-// 		Base:   0,
-// 		Detail: make(map[string]interface{}),
-// 	}
-
-// 	for i := 0; i < len(tasks); i++ {
-// 		result := <-resultChan
-
-// 		if result.Error != nil {
-// 			return nil, fmt.Errorf("error in %s calculation: %w", result.Name, result.Error)
-// 		}
-
-// 		// 合併結果
-// 		combinedFlags = append(combinedFlags, result.Flags...)
-// 		combinedScore.Base += result.Score.Base
-// 		for k, v := range result.Score.Detail {
-// 			combinedScore.Detail[k] = v
-// 		}
-// 	}
-
-// 	close(resultChan)
-
-// 	// 計算最終風險評分
-// 	totalRisk := i.calcScore(combinedScore)
-
-// 	if totalRisk > 100 {
-// 		i.Manager.Block.Add(device.IP.Address, "Score greater than 100")
-// 	}
-
-// 	return &ScoreItem{
-// 		IsBlock:      totalRisk >= 100,
-// 		IsSuspicious: totalRisk >= i.Config.Parameter.ScoreSuspicious,
-// 		IsDangerous:  totalRisk >= i.Config.Parameter.ScoreDangerous,
-// 		Flag:         combinedFlags,
-// 		Score:        totalRisk,
-// 		Detail:       combinedScore.Detail,
-// 	}, nil
-// }
-
 type BasicItem struct {
 	key       string
 	value     string
@@ -301,92 +139,63 @@ type BasicItem struct {
 	riskPoint int
 }
 
-func (i *IPGuardian) calcBasic(device *Device, flags *[]string, riskScore *RiskScore) error {
+func (i *IPGuardian) calcBasic(ctx context.Context, p *policy, device *Device, flags *[]string, riskScore *RiskScore) error {
 	if err := validateDevice(device); err != nil {
 		return err
 	}
 
-	if i.Config.Parameter.SessionMultiIP <= 0 {
-		i.Config.Parameter.SessionMultiIP = 4
-	}
-
-	if i.Config.Parameter.ScoreSessionMultiIP <= 0 {
-		i.Config.Parameter.ScoreSessionMultiIP = 25
-	}
-
-	if i.Config.Parameter.IPMultiDevice <= 0 {
-		i.Config.Parameter.IPMultiDevice = 8
-	}
-	if i.Config.Parameter.ScoreIPMultiDevice <= 0 {
-		i.Config.Parameter.ScoreIPMultiDevice = 20
-	}
-
-	if i.Config.Parameter.DeviceMultiIP <= 0 {
-		i.Config.Parameter.DeviceMultiIP = 4
-	}
-	if i.Config.Parameter.ScoreDeviceMultiIP <= 0 {
-		i.Config.Parameter.ScoreDeviceMultiIP = 15
-	}
-
-	if i.Config.Parameter.LoginFailure <= 0 {
-		i.Config.Parameter.LoginFailure = 4
-	}
-	if i.Config.Parameter.NotFound404 <= 0 {
-		i.Config.Parameter.NotFound404 = 8
-	}
-
-	if i.Config.Parameter.ScoreLoginFailure <= 0 {
-		i.Config.Parameter.ScoreLoginFailure = 15
-	}
-	if i.Config.Parameter.ScoreNotFound404 <= 0 {
-		i.Config.Parameter.ScoreNotFound404 = 15
-	}
-
-	operations := []BasicItem{
+	all := []BasicItem{
 		{
 			key:       fmt.Sprintf(redisSessionIP, device.SessionID),
 			value:     device.IP.Address,
-			threshold: i.Config.Parameter.SessionMultiIP,
+			threshold: p.SessionMultiIP,
 			flagName:  "session_multi_ip",
-			riskPoint: i.Config.Parameter.ScoreSessionMultiIP,
+			riskPoint: p.ScoreSessionMultiIP,
 		},
 		{
 			key:       fmt.Sprintf(redisIPDevice, device.IP.Address),
 			value:     device.Fingerprint,
-			threshold: i.Config.Parameter.IPMultiDevice,
+			threshold: p.IPMultiDevice,
 			flagName:  "ip_multi_device",
-			riskPoint: i.Config.Parameter.ScoreIPMultiDevice,
+			riskPoint: p.ScoreIPMultiDevice,
 		},
 		{
 			key:       fmt.Sprintf(redisDeviceFp, device.Fingerprint),
 			value:     device.IP.Address,
-			threshold: i.Config.Parameter.DeviceMultiIP,
+			threshold: p.DeviceMultiIP,
 			flagName:  "device_multi_ip",
-			riskPoint: i.Config.Parameter.ScoreDeviceMultiIP,
+			riskPoint: p.ScoreDeviceMultiIP,
 		},
 	}
 
-	pipe := i.Redis.Pipeline()
-	var countCmds []*redis.IntCmd
+	var operations []BasicItem
+	for _, op := range all {
+		if op.threshold != Disabled {
+			operations = append(operations, op)
+		}
+	}
+
+	pipe := i.Store.Pipeline()
+	var countCmds []*StoreCmd
 
 	for _, op := range operations {
-		pipe.SAdd(i.Context, op.key, op.value)
-		countCmds = append(countCmds, pipe.SCard(i.Context, op.key))
-		pipe.Expire(i.Context, op.key, time.Hour)
+		pipe.SAdd(ctx, op.key, op.value)
+		countCmds = append(countCmds, pipe.SCard(ctx, op.key))
+		pipe.Expire(ctx, op.key, time.Hour)
 	}
 
 	notFound404Key := fmt.Sprintf(redisNotFound404, device.SessionID)
 	loginFailureKey := fmt.Sprintf(redisLoginFailure, device.SessionID)
 
-	notFound404Cmd := pipe.Get(i.Context, notFound404Key)
-	loginFailureCmd := pipe.Get(i.Context, loginFailureKey)
+	notFound404Cmd := pipe.Get(ctx, notFound404Key)
+	loginFailureCmd := pipe.Get(ctx, loginFailureKey)
 
-	if _, err := pipe.Exec(i.Context); err != nil && err.Error() != "redis: nil" {
+	if err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to execute redis pipeline: %w", err)
 	}
 
 	for i, op := range operations {
-		count, err := countCmds[i].Result()
+		count, err := countCmds[i].Int()
 		if err != nil {
 			return fmt.Errorf("failed to get count for %s: %w", op.key, err)
 		}
@@ -402,29 +211,29 @@ func (i *IPGuardian) calcBasic(device *Device, flags *[]string, riskScore *RiskS
 		}
 	}
 
-	if notFound404Count, err := notFound404Cmd.Result(); err == nil {
+	if notFound404Count, err := notFound404Cmd.String(); err == nil && p.NotFound404 != Disabled {
 		if count, parseErr := strconv.Atoi(notFound404Count); parseErr == nil {
-			if count > int(math.Floor(float64(i.Config.Parameter.NotFound404)*1.5)) {
+			if count > int(math.Floor(float64(p.NotFound404)*1.5)) {
 				*flags = append(*flags, "excessive_404_errors")
-				riskScore.Base += i.Config.Parameter.ScoreNotFound404 * 2
+				riskScore.Base += p.ScoreNotFound404 * 2
 				riskScore.Detail["notFound404Count"] = count
-			} else if count > i.Config.Parameter.NotFound404 {
+			} else if count > p.NotFound404 {
 				*flags = append(*flags, "frequent_404_errors")
-				riskScore.Base += i.Config.Parameter.ScoreNotFound404
+				riskScore.Base += p.ScoreNotFound404
 				riskScore.Detail["notFound404Count"] = count
 			}
 		}
 	}
 
-	if loginFailureCount, err := loginFailureCmd.Result(); err == nil {
+	if loginFailureCount, err := loginFailureCmd.String(); err == nil && p.LoginFailure != Disabled {
 		if count, parseErr := strconv.Atoi(loginFailureCount); parseErr == nil {
-			if count > int(math.Floor(float64(i.Config.Parameter.LoginFailure)*1.5)) {
+			if count > int(math.Floor(float64(p.LoginFailure)*1.5)) {
 				*flags = append(*flags, "excessive_login_failures")
-				riskScore.Base += i.Config.Parameter.ScoreLoginFailure * 2
+				riskScore.Base += p.ScoreLoginFailure * 2
 				riskScore.Detail["loginFailureCount"] = count
-			} else if count > i.Config.Parameter.LoginFailure {
+			} else if count > p.LoginFailure {
 				*flags = append(*flags, "frequent_login_failures")
-				riskScore.Base += i.Config.Parameter.ScoreLoginFailure
+				riskScore.Base += p.ScoreLoginFailure
 				riskScore.Detail["loginFailureCount"] = count
 			}
 		}
@@ -433,7 +242,7 @@ func (i *IPGuardian) calcBasic(device *Device, flags *[]string, riskScore *RiskS
 	return nil
 }
 
-func (i *IPGuardian) calcGeo(device *Device, flags *[]string, score *RiskScore) error {
+func (i *IPGuardian) calcGeo(ctx context.Context, p *policy, device *Device, flags *[]string, score *RiskScore) error {
 	if err := validateDevice(device); err != nil {
 		return err
 	}
@@ -442,9 +251,9 @@ func (i *IPGuardian) calcGeo(device *Device, flags *[]string, score *RiskScore)
 		return nil
 	}
 
-	record, err := i.GeoLite2.location(device.IP.Address)
+	record, err := i.GeoLite2.location(ctx, device.IP.Address)
 	if err != nil {
-		log.Printf("Failed to get geo record for IP %s: %v", device.IP.Address, err)
+		i.Logger.WarnError(err, "Failed to get geo record, IP: "+device.IP.Address)
 		return nil
 	}
 
@@ -454,27 +263,25 @@ func (i *IPGuardian) calcGeo(device *Device, flags *[]string, score *RiskScore)
 	geoKey := fmt.Sprintf(redisGeoLocation, device.SessionID)
 	locationWithTime := fmt.Sprintf("%d:%s", time.Now().UTC().UnixMilli(), location)
 
-	log.Print(location, geoKey)
-
 	// Redis操作批量處理
-	pipe := i.Redis.Pipeline()
-	pipe.LPush(i.Context, geoKey, locationWithTime)
-	pipe.LTrim(i.Context, geoKey, 0, 9)
-	pipe.Expire(i.Context, geoKey, 24*time.Hour)
-	_, err = pipe.Exec(i.Context)
+	pipe := i.Store.Pipeline()
+	pipe.LPush(ctx, geoKey, locationWithTime)
+	pipe.LTrim(ctx, geoKey, 0, 9)
+	pipe.Expire(ctx, geoKey, 24*time.Hour)
+	err = pipe.Exec(ctx)
 	if err != nil {
 		return err
 	}
 
-	locations, err := i.Redis.LRange(i.Context, geoKey, 0, -1).Result()
+	locations, err := i.Store.LRange(ctx, geoKey, 0, -1)
 	if err != nil {
 		return err
 	}
 
-	return i.GeoLite2.risk(locations, flags, score)
+	return i.GeoLite2.risk(p, locations, flags, score)
 }
 
-func (i *IPGuardian) calcBehavior(device *Device, flags *[]string, score *RiskScore) error {
+func (i *IPGuardian) calcBehavior(ctx context.Context, p *policy, device *Device, flags *[]string, score *RiskScore) error {
 	if err := validateDevice(device); err != nil {
 		return err
 	}
@@ -482,36 +289,35 @@ func (i *IPGuardian) calcBehavior(device *Device, flags *[]string, score *RiskSc
 	intervalKey := fmt.Sprintf(redisIntervalLast, device.SessionID)
 	sessionStartKey := fmt.Sprintf(redisSessionStart, device.SessionID)
 
-	pipe := i.Redis.Pipeline()
-	lastRequestCmd := pipe.Get(i.Context, intervalKey)
-	sessionStartCmd := pipe.Get(i.Context, sessionStartKey)
-	_, err := pipe.Exec(i.Context)
+	pipe := i.Store.Pipeline()
+	lastRequestCmd := pipe.Get(ctx, intervalKey)
+	sessionStartCmd := pipe.Get(ctx, sessionStartKey)
+	if err := pipe.Exec(ctx); err != nil {
+		// * empty results would look like a new session
+		return err
+	}
 
-	lastRequestStr, err1 := lastRequestCmd.Result()
+	lastRequestStr, err1 := lastRequestCmd.String()
 	if err1 == nil && lastRequestStr != "" {
 		lastRequest, _ := strconv.ParseInt(lastRequestStr, 10, 64)
 		timeDiff := time.Now().UTC().UnixMilli() - lastRequest
 		intervalHistoryKey := fmt.Sprintf(redisInterval, device.SessionID)
 
-		pipe2 := i.Redis.Pipeline()
-		pipe2.LPush(i.Context, intervalHistoryKey, timeDiff)
-		pipe2.LTrim(i.Context, intervalHistoryKey, 0, 9)
-		pipe2.Expire(i.Context, intervalHistoryKey, time.Hour)
-		intervalsCmd := pipe2.LRange(i.Context, intervalHistoryKey, 0, 9)
-		_, err := pipe2.Exec(i.Context)
+		pipe2 := i.Store.Pipeline()
+		pipe2.LPush(ctx, intervalHistoryKey, timeDiff)
+		pipe2.LTrim(ctx, intervalHistoryKey, 0, 9)
+		pipe2.Expire(ctx, intervalHistoryKey, time.Hour)
+		intervalsCmd := pipe2.LRange(ctx, intervalHistoryKey, 0, 9)
+		err := pipe2.Exec(ctx)
 		if err != nil {
 			return err
 		}
 
-		intervals, err := intervalsCmd.Result()
+		intervals, err := intervalsCmd.Strings()
 		if err != nil {
 			return err
 		}
 
-		if i.Config.Parameter.ScoreIntervalRequest <= 0 {
-			i.Config.Parameter.ScoreIntervalRequest = 25
-		}
-
 		if len(intervals) >= 5 {
 			var sum int64
 			var values []int64
@@ -536,7 +342,7 @@ func (i *IPGuardian) calcBehavior(device *Device, flags *[]string, score *RiskSc
 
 			if variance < 1000 && avgInterval > 500 && avgInterval < 30000 {
 				*flags = append(*flags, "interval_request")
-				score.Base += i.Config.Parameter.ScoreIntervalRequest
+				score.Base += p.ScoreIntervalRequest
 				score.Detail["regularInterval"] = map[string]interface{}{
 					"avg":      avgInterval,
 					"variance": variance,
@@ -545,7 +351,7 @@ func (i *IPGuardian) calcBehavior(device *Device, flags *[]string, score *RiskSc
 
 			if tooFastCount >= 16 {
 				*flags = append(*flags, "too_frequent_requests")
-				score.Base += i.Config.Parameter.ScoreFrequencyRequest
+				score.Base += p.ScoreFrequencyRequest
 				score.Detail["tooFrequentRequests"] = map[string]interface{}{
 					"count":        tooFastCount,
 					"totalChecked": len(values),
@@ -554,76 +360,67 @@ func (i *IPGuardian) calcBehavior(device *Device, flags *[]string, score *RiskSc
 
 			if variance < 100 && len(values) >= 8 {
 				*flags = append(*flags, "extremely_regular")
-				score.Base += int(float64(i.Config.Parameter.ScoreIntervalRequest) * 1.5)
+				score.Base += int(float64(p.ScoreIntervalRequest) * 1.5)
 				score.Detail["extremelyRegular"] = variance
 			}
 		}
 	}
 
-	if i.Config.Parameter.ScoreLongConnection <= 0 {
-		i.Config.Parameter.ScoreLongConnection = 15
-	}
-
-	sessionStartStr, err2 := sessionStartCmd.Result()
+	sessionStartStr, err2 := sessionStartCmd.String()
 
-	pipe3 := i.Redis.Pipeline()
-	pipe3.SetEx(i.Context, intervalKey, time.Now().UTC().UnixMilli(), time.Hour)
+	pipe3 := i.Store.Pipeline()
+	pipe3.Set(ctx, intervalKey, time.Now().UTC().UnixMilli(), time.Hour)
 
-	if err2 == redis.Nil {
-		pipe3.SetEx(i.Context, sessionStartKey, time.Now().UTC().UnixMilli(), 15*time.Minute)
+	if err2 == ErrNil {
+		pipe3.Set(ctx, sessionStartKey, time.Now().UTC().UnixMilli(), 15*time.Minute)
 	} else if err2 == nil {
-		pipe3.Expire(i.Context, sessionStartKey, 15*time.Minute)
+		pipe3.Expire(ctx, sessionStartKey, 15*time.Minute)
 
 		sessionStart, _ := strconv.ParseInt(sessionStartStr, 10, 64)
 		duration := time.Now().UTC().UnixMilli() - sessionStart
 
 		if duration > 4*3600*1000 {
 			*flags = append(*flags, "extremely_long_connection")
-			score.Base += i.Config.Parameter.ScoreLongConnection * 2
+			score.Base += p.ScoreLongConnection * 2
 			score.Detail["sessionDuration"] = duration
 		} else if duration > 2*3600*1000 {
 			*flags = append(*flags, "long_connection")
-			score.Base += int(float64(i.Config.Parameter.ScoreLongConnection) * 1.5)
+			score.Base += int(float64(p.ScoreLongConnection) * 1.5)
 			score.Detail["sessionDuration"] = duration
 		} else if duration > 1*3600*1000 {
 			*flags = append(*flags, "moderate_long_connection")
-			score.Base += i.Config.Parameter.ScoreLongConnection
+			score.Base += p.ScoreLongConnection
 			score.Detail["sessionDuration"] = duration
 		}
 	}
 
-	_, err = pipe3.Exec(i.Context)
-	return err
+	return pipe3.Exec(ctx)
 }
 
-func (i *IPGuardian) calcFingerprint(device *Device, flags *[]string, score *RiskScore) error {
+func (i *IPGuardian) calcFingerprint(ctx context.Context, p *policy, device *Device, flags *[]string, score *RiskScore) error {
 	if err := validateDevice(device); err != nil {
 		return err
 	}
 
-	if i.Config.Parameter.ScoreFpMultiSession <= 0 {
-		i.Config.Parameter.ScoreFpMultiSession = 50
-	}
-
 	currentMinute := time.Now().UTC().UnixMilli() / 60000
 	fingerprintSessionKey := fmt.Sprintf(redisFpSession, currentMinute, device.Fingerprint)
 
-	if err := i.Redis.SAdd(i.Context, fingerprintSessionKey, device.SessionID).Err(); err != nil {
+	if err := i.Store.SAdd(ctx, fingerprintSessionKey, device.SessionID); err != nil {
 		return err
 	}
 
-	if err := i.Redis.Expire(i.Context, fingerprintSessionKey, time.Minute).Err(); err != nil {
+	if err := i.Store.Expire(ctx, fingerprintSessionKey, time.Minute); err != nil {
 		return err
 	}
 
-	sessionCount, err := i.Redis.SCard(i.Context, fingerprintSessionKey).Result()
+	sessionCount, err := i.Store.SCard(ctx, fingerprintSessionKey)
 	if err != nil {
 		return err
 	}
 
 	if int(sessionCount) > 2 {
 		*flags = append(*flags, "fp_multi_session")
-		score.Base += i.Config.Parameter.ScoreFpMultiSession
+		score.Base += p.ScoreFpMultiSession
 		score.Detail["fingerprintSessions"] = sessionCount
 	}
 
@@ -674,20 +471,25 @@ func getMapKeys(m map[string]bool) []string {
 
 // * public
 func (i *IPGuardian) NotFound404(w http.ResponseWriter, r *http.Request) error {
-	device, err := i.getDevice(w, r)
+	return i.NotFound404Request(NewHTTPRequest(w, r))
+}
+
+// * NotFound404 for any framework, see Request
+func (i *IPGuardian) NotFound404Request(req Request) error {
+	device, err := i.getDevice(req)
 	if err != nil {
 		return i.Logger.Error(err, "Failed to get device")
 	}
 
 	key := fmt.Sprintf(redisNotFound404, device.SessionID)
 
-	count, err := i.Redis.Incr(i.Context, key).Result()
+	count, err := i.Store.Incr(i.Context, key)
 	if err != nil {
 		return i.Logger.Error(err, "Failed to increase 404 count")
 	}
 
 	if count == 1 {
-		if err := i.Redis.Expire(i.Context, key, time.Hour).Err(); err != nil {
+		if err := i.Store.Expire(i.Context, key, time.Hour); err != nil {
 			return i.Logger.Error(err, "Failed to set expiration for 404 count")
 		}
 	}
@@ -697,20 +499,25 @@ func (i *IPGuardian) NotFound404(w http.ResponseWriter, r *http.Request) error {
 
 // * public
 func (i *IPGuardian) LoginFailure(w http.ResponseWriter, r *http.Request) error {
-	device, err := i.getDevice(w, r)
+	return i.LoginFailureRequest(NewHTTPRequest(w, r))
+}
+
+// * LoginFailure for any framework, see Request
+func (i *IPGuardian) LoginFailureRequest(req Request) error {
+	device, err := i.getDevice(req)
 	if err != nil {
 		return i.Logger.Error(err, "Failed to get device")
 	}
 
 	key := fmt.Sprintf(redisLoginFailure, device.SessionID)
 
-	count, err := i.Redis.Incr(i.Context, key).Result()
+	count, err := i.Store.Incr(i.Context, key)
 	if err != nil {
 		return i.Logger.Error(err, "Failed to increase login failure count")
 	}
 
 	if count == 1 {
-		if err := i.Redis.Expire(i.Context, key, time.Hour).Err(); err != nil {
+		if err := i.Store.Expire(i.Context, key, time.Hour); err != nil {
 			return i.Logger.Error(err, "Failed to set expiration for login failure count")
 		}
 	}
@@ -0,0 +1,126 @@
+package golangIPSentry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// * custom risk factor, e.g. account age or payment risk
+// * `device` is shared between scorers and must be treated as read-only
+// * `score.Detail` is stored under `ScoreItem.Detail[Name()]`
+type Scorer interface {
+	Name() string
+	Score(ctx context.Context, device *Device, flags *[]string, score *RiskScore) error
+}
+
+var builtinScorers = map[string]bool{
+	"basic":       true,
+	"geo":         true,
+	"behavior":    true,
+	"fingerprint": true,
+	"abuseipdb":   true,
+}
+
+// * timeout <= 0 uses default 500ms, a scorer that fails or times out is skipped
+func (i *IPGuardian) RegisterScorer(scorer Scorer, timeout time.Duration) error {
+	if scorer == nil {
+		return fmt.Errorf("scorer is nil")
+	}
+
+	name := scorer.Name()
+	if name == "" {
+		return fmt.Errorf("scorer name is empty")
+	}
+	if builtinScorers[name] {
+		return fmt.Errorf("scorer %s is reserved", name)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultScorerTimeout
+	}
+
+	i.scorerMutex.Lock()
+	defer i.scorerMutex.Unlock()
+
+	for _, task := range i.scorers {
+		if task.Name == name {
+			return fmt.Errorf("scorer %s is already registered", name)
+		}
+	}
+
+	i.scorers = append(i.scorers, ScoreTask{
+		Name:    name,
+		Func:    scorer.Score,
+		Timeout: timeout,
+		Custom:  true,
+	})
+
+	return nil
+}
+
+// * built-in scorers read the policy of the current check
+func (i *IPGuardian) scoreTasks(p *policy) []ScoreTask {
+	timeout := i.Config.ScorerTimeout
+	abuseIPDBTimeout := defaultScorerTimeout
+	if i.AbuseIPDBApi != nil {
+		abuseIPDBTimeout = i.AbuseIPDBApi.Timeout
+	}
+
+	tasks := []ScoreTask{
+		{Name: "basic", Func: builtinScorer(p, i.calcBasic), Timeout: timeout.Basic},
+		{Name: "geo", Func: builtinScorer(p, i.calcGeo), Timeout: timeout.Geo},
+		{Name: "behavior", Func: builtinScorer(p, i.calcBehavior), Timeout: timeout.Behavior},
+		{Name: "fingerprint", Func: builtinScorer(p, i.calcFingerprint), Timeout: timeout.Fingerprint},
+		{Name: "abuseipdb", Func: builtinScorer(p, i.calcAbuseIPDB), Timeout: abuseIPDBTimeout},
+	}
+
+	i.scorerMutex.RLock()
+	tasks = append(tasks, i.scorers...)
+	i.scorerMutex.RUnlock()
+
+	return tasks
+}
+
+// * Store calls of built-in scorers use ctx, they stop when the task times out
+func builtinScorer(p *policy, fn func(context.Context, *policy, *Device, *[]string, *RiskScore) error) func(context.Context, *Device, *[]string, *RiskScore) error {
+	return func(ctx context.Context, device *Device, flags *[]string, score *RiskScore) error {
+		return fn(ctx, p, device, flags, score)
+	}
+}
+
+func newScoreResult(name string) ScoreResult {
+	return ScoreResult{
+		Name: name,
+		Score: RiskScore{
+			Base:   0,
+			Detail: make(map[string]interface{}),
+		},
+	}
+}
+
+func (i *IPGuardian) runScoreTask(task ScoreTask, device *Device) ScoreResult {
+	if task.Timeout <= 0 {
+		task.Timeout = defaultScorerTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(i.Context, task.Timeout)
+	defer cancel()
+
+	done := make(chan ScoreResult, 1)
+	go func() {
+		result := newScoreResult(task.Name)
+		result.Error = task.Func(ctx, device, &result.Flags, &result.Score)
+		done <- result
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		// * late result is dropped, the buffered channel lets the goroutine exit
+		result := newScoreResult(task.Name)
+		result.Error = fmt.Errorf("scorer %s timed out after %s: %w", task.Name, task.Timeout, ctx.Err())
+		return result
+	}
+}
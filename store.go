@@ -0,0 +1,137 @@
+package golangIPSentry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// * returned by Get when the key does not exist
+var ErrNil = errors.New("store: nil")
+
+// * storage backend used by the scorer and managers
+type Store interface {
+	Ping(ctx context.Context) error
+	Close() error
+
+	Get(ctx context.Context, key string) (string, error)
+	MGet(ctx context.Context, keys ...string) ([]string, error) // * missing key returns ""
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) (int64, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	TTL(ctx context.Context, key string) (time.Duration, error) // * -1 for no expiration, -2 for missing key
+	Scan(ctx context.Context, pattern string) ([]string, error)
+
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SCard(ctx context.Context, key string) (int64, error)
+
+	LPush(ctx context.Context, key string, values ...interface{}) error
+	LTrim(ctx context.Context, key string, start, stop int64) error
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+
+	// * atomic, set key only when version is greater than the one stored in versionKey
+	// * false when an equal or newer version is stored
+	SetVersion(ctx context.Context, key, versionKey string, version int64, value interface{}) (bool, error)
+
+	// * atomic check of the algorithm, consume false does not count the request
+	RateLimit(ctx context.Context, key string, algorithm string, limit RateLimit, consume bool) (*RateLimitResult, error)
+
+	Pipeline() Pipeline
+}
+
+// * optional, lets instances sharing a Store push messages to each other, e.g. policy updates
+type PubSub interface {
+	Publish(ctx context.Context, channel string, message string) error
+	// * messages published after it returns, the channel is closed when ctx is done
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// * queued commands applied atomically, results are available after Exec
+type Pipeline interface {
+	Get(ctx context.Context, key string) *StoreCmd
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *StoreCmd
+	Del(ctx context.Context, keys ...string) *StoreCmd
+	Incr(ctx context.Context, key string) *StoreCmd
+	Expire(ctx context.Context, key string, ttl time.Duration) *StoreCmd
+	SAdd(ctx context.Context, key string, members ...interface{}) *StoreCmd
+	SCard(ctx context.Context, key string) *StoreCmd
+	LPush(ctx context.Context, key string, values ...interface{}) *StoreCmd
+	LTrim(ctx context.Context, key string, start, stop int64) *StoreCmd
+	LRange(ctx context.Context, key string, start, stop int64) *StoreCmd
+	// * return the first error except ErrNil
+	Exec(ctx context.Context) error
+}
+
+type StoreCmd struct {
+	val interface{}
+	err error
+}
+
+func (c *StoreCmd) Err() error {
+	return c.err
+}
+
+func (c *StoreCmd) String() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	switch v := c.val.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+func (c *StoreCmd) Int() (int64, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	switch v := c.val.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("store: unexpected type %T", c.val)
+	}
+}
+
+func (c *StoreCmd) Strings() ([]string, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if v, ok := c.val.([]string); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("store: unexpected type %T", c.val)
+}
+
+// * same formatting as redis arguments
+func storeString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
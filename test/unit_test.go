@@ -1,15 +1,48 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labstack/echo/v4"
 	golangIPSentry "github.com/pardnchiu/golang-ip-sentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/chisentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/echosentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/fasthttpsentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/fibersentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/ginsentry"
+	"github.com/pardnchiu/golang-ip-sentry/adapter/grpcsentry"
+	"github.com/pardnchiu/golang-ip-sentry/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var testConfig = golangIPSentry.Config{
@@ -19,6 +52,7 @@ var testConfig = golangIPSentry.Config{
 		Password: "0123456789",
 		DB:       0, // 使用測試專用 DB
 	},
+	TrustedProxies: []string{"127.0.0.1/32"},
 	Log: &golangIPSentry.Log{
 		Path:    "./logs/test",
 		Stdout:  false,
@@ -28,9 +62,9 @@ var testConfig = golangIPSentry.Config{
 		BlockToBan:             3,
 		BlockTimeMin:           30 * time.Minute,
 		BlockTimeMax:           1800 * time.Minute,
-		RateLimitNormal:        10,
-		RateLimitSuspicious:    5,
-		RateLimitDangerous:     3,
+		RateLimitNormal:        golangIPSentry.RateLimit{Limit: 10},
+		RateLimitSuspicious:    golangIPSentry.RateLimit{Limit: 5},
+		RateLimitDangerous:     golangIPSentry.RateLimit{Limit: 3},
 		SessionMultiIP:         2,
 		IPMultiDevice:          3,
 		DeviceMultiIP:          2,
@@ -51,19 +85,23 @@ var testConfig = golangIPSentry.Config{
 	},
 }
 
-func setupTestGuardian(t *testing.T) *golangIPSentry.IPGuardian {
-	guardian, err := golangIPSentry.New(testConfig)
-	require.NoError(t, err)
+func setupTestGuardian(t testing.TB) *golangIPSentry.IPGuardian {
+	// 使用記憶體儲存，測試不需要 Redis 服務
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
 
-	// 清理測試 Redis 資料
-	// guardian.Redis.FlushDB(context.Background())
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
 
 	return guardian
 }
 
 func teardownTestGuardian(guardian *golangIPSentry.IPGuardian) {
 	if guardian != nil {
-		// guardian.Redis.FlushDB(context.Background())
 		guardian.Close()
 	}
 }
@@ -74,7 +112,7 @@ func TestIPGuardianInitialization(t *testing.T) {
 	defer teardownTestGuardian(guardian)
 
 	assert.NotNil(t, guardian)
-	assert.NotNil(t, guardian.Redis)
+	assert.NotNil(t, guardian.Store)
 	assert.NotNil(t, guardian.Logger)
 	assert.NotNil(t, guardian.Manager)
 	assert.NotNil(t, guardian.Manager.Allow)
@@ -82,13 +120,60 @@ func TestIPGuardianInitialization(t *testing.T) {
 	assert.NotNil(t, guardian.Manager.Deny)
 }
 
-// TestRedisConnection 測試 Redis 連線
-func TestRedisConnection(t *testing.T) {
+// TestStoreConnection 測試儲存連線
+func TestStoreConnection(t *testing.T) {
 	guardian := setupTestGuardian(t)
 	defer teardownTestGuardian(guardian)
 
-	result := guardian.Redis.Ping(context.Background())
-	assert.NoError(t, result.Err())
+	assert.NoError(t, guardian.Store.Ping(context.Background()))
+}
+
+// TestMemoryStore 測試記憶體儲存
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := golangIPSentry.NewMemoryStore()
+	defer store.Close()
+
+	// 測試計數與過期
+	count, err := store.Incr(ctx, "counter")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.NoError(t, store.Expire(ctx, "counter", 20*time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+	_, err = store.Get(ctx, "counter")
+	assert.ErrorIs(t, err, golangIPSentry.ErrNil)
+
+	// 測試 TTL
+	assert.NoError(t, store.Set(ctx, "ttl", "value", time.Minute))
+	ttl, err := store.TTL(ctx, "ttl")
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 50*time.Second)
+
+	// 測試管線
+	pipe := store.Pipeline()
+	pipe.SAdd(ctx, "set", "a", "b", "a")
+	card := pipe.SCard(ctx, "set")
+	pipe.LPush(ctx, "list", 1, 2, 3)
+	pipe.LTrim(ctx, "list", 0, 1)
+	items := pipe.LRange(ctx, "list", 0, -1)
+	missing := pipe.Get(ctx, "missing")
+	assert.NoError(t, pipe.Exec(ctx))
+
+	size, err := card.Int()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	list, err := items.Strings()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, list)
+
+	_, err = missing.String()
+	assert.ErrorIs(t, err, golangIPSentry.ErrNil)
+
+	// 測試掃描
+	keys, err := store.Scan(ctx, "l*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"list"}, keys)
 }
 
 // TestAllowIPManager 測試信任 IP 管理
@@ -133,6 +218,32 @@ func TestDenyIPManager(t *testing.T) {
 	assert.False(t, isNotBanned)
 }
 
+// TestIPManagerCIDR 測試 CIDR 與 IP 範圍
+func TestIPManagerCIDR(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	// 測試 IPv4 CIDR
+	assert.NoError(t, guardian.Manager.Allow.Add("203.0.113.0/24", "辦公室網段"))
+	assert.True(t, guardian.Manager.Allow.Check("203.0.113.77"))
+	assert.False(t, guardian.Manager.Allow.Check("203.0.114.1"))
+
+	// 測試 IPv6 CIDR
+	assert.NoError(t, guardian.Manager.Allow.Add("2001:db8::/32", "合作夥伴網段"))
+	assert.True(t, guardian.Manager.Allow.Check("2001:db8:1::1"))
+	assert.False(t, guardian.Manager.Allow.Check("2001:db9::1"))
+
+	// 測試 IP 範圍
+	assert.NoError(t, guardian.Manager.Deny.Add("198.51.100.10-198.51.100.20", "主機商網段"))
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.10"))
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.20"))
+	assert.False(t, guardian.Manager.Deny.Check("198.51.100.21"))
+
+	// 測試無效格式
+	assert.Error(t, guardian.Manager.Deny.Add("198.51.100.0/33", "無效"))
+	assert.Error(t, guardian.Manager.Deny.Add("198.51.100.20-198.51.100.10", "無效"))
+}
+
 // TestBlockIPManager 測試封鎖 IP 管理
 func TestBlockIPManager(t *testing.T) {
 	guardian := setupTestGuardian(t)
@@ -154,6 +265,98 @@ func TestBlockIPManager(t *testing.T) {
 	assert.False(t, isNotBlocked)
 }
 
+// TestIPManagerRemoveListGet 測試移除、查詢與列表
+func TestIPManagerRemoveListGet(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	t.Run("黑名單", func(t *testing.T) {
+		testIP := "9.9.9.9"
+		assert.NoError(t, guardian.Manager.Deny.Add(testIP, "測試移除"))
+
+		item, err := guardian.Manager.Deny.Get(testIP)
+		assert.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, "測試移除", item.Reason)
+
+		list, total, err := guardian.Manager.Deny.List(1, 1)
+		assert.NoError(t, err)
+		assert.Len(t, list, 1)
+		assert.GreaterOrEqual(t, total, 1)
+
+		assert.NoError(t, guardian.Manager.Deny.Remove(testIP))
+		assert.False(t, guardian.Manager.Deny.Check(testIP))
+		assert.Error(t, guardian.Manager.Deny.Remove(testIP))
+	})
+
+	t.Run("白名單 CIDR", func(t *testing.T) {
+		assert.NoError(t, guardian.Manager.Allow.Add("100.64.0.0/10", "測試移除"))
+
+		item, err := guardian.Manager.Allow.Get("100.64.1.1")
+		assert.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, "100.64.0.0/10", item.IP)
+
+		assert.NoError(t, guardian.Manager.Allow.Remove("100.64.0.0/10"))
+		assert.False(t, guardian.Manager.Allow.Check("100.64.1.1"))
+	})
+
+	t.Run("重疊條目", func(t *testing.T) {
+		// 同一前綴的 CIDR 與範圍，移除其中一個不影響另一個
+		assert.NoError(t, guardian.Manager.Allow.Add("10.1.0.0/24", "CIDR"))
+		assert.NoError(t, guardian.Manager.Allow.Add("10.1.0.0-10.1.0.255", "範圍"))
+		assert.NoError(t, guardian.Manager.Allow.Add("10.1.0.5", "單一 IP"))
+
+		assert.NoError(t, guardian.Manager.Allow.Remove("10.1.0.0-10.1.0.255"))
+		item, err := guardian.Manager.Allow.Get("10.1.0.7")
+		assert.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, "10.1.0.0/24", item.IP)
+
+		assert.NoError(t, guardian.Manager.Allow.Remove("10.1.0.0/24"))
+		assert.False(t, guardian.Manager.Allow.Check("10.1.0.7"))
+		assert.True(t, guardian.Manager.Allow.Check("10.1.0.5"))
+		assert.NoError(t, guardian.Manager.Allow.Remove("10.1.0.5"))
+		assert.False(t, guardian.Manager.Allow.Check("10.1.0.5"))
+	})
+
+	t.Run("僅存在於 Store", func(t *testing.T) {
+		// 其他實例新增的條目，Get 與 Check 結果一致
+		item := golangIPSentry.IPItem{IP: "9.9.9.11", Reason: "其他實例"}
+		data, err := json.Marshal(item)
+		require.NoError(t, err)
+		require.NoError(t, guardian.Store.Set(context.Background(), "deny:9.9.9.11", data, 0))
+
+		assert.True(t, guardian.Manager.Deny.Check("9.9.9.11"))
+		match, err := guardian.Manager.Deny.Get("9.9.9.11")
+		assert.NoError(t, err)
+		require.NotNil(t, match)
+		assert.Equal(t, "其他實例", match.Reason)
+
+		result := guardian.Check(createTestRequest("9.9.9.11"), httptest.NewRecorder())
+		assert.Equal(t, golangIPSentry.ReasonDenyList, result.Reason)
+		require.NotNil(t, result.Match)
+		assert.Equal(t, "其他實例", result.Match.Reason)
+	})
+
+	t.Run("封鎖名單", func(t *testing.T) {
+		testIP := "9.9.9.10"
+		assert.NoError(t, guardian.Manager.Block.Add(testIP, "測試解除封鎖"))
+
+		item, err := guardian.Manager.Block.Get(testIP)
+		assert.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, 1, item.Count)
+
+		_, total, err := guardian.Manager.Block.List(1, 10)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, total, 1)
+
+		assert.NoError(t, guardian.Manager.Block.Remove(testIP))
+		assert.False(t, guardian.Manager.Block.IsBlock(testIP))
+	})
+}
+
 // TestIPGuardianCheck 測試主要檢查功能
 func TestIPGuardianCheck(t *testing.T) {
 	guardian := setupTestGuardian(t)
@@ -233,7 +436,1705 @@ func TestIPGuardianCheck(t *testing.T) {
 // 	assert.Contains(t, result.Error, "rate limit")
 // }
 
+// TestAbuseIPDB 測試 AbuseIPDB 信譽檢查
+func TestAbuseIPDB(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		assert.Equal(t, "/check", r.URL.Path)
+		assert.Equal(t, "test-token", r.Header.Get("Key"))
+
+		score := 0
+		switch r.URL.Query().Get("ipAddress") {
+		case "198.51.100.66":
+			score = 100
+		case "198.51.100.68":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case "198.51.100.70":
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case "198.51.100.72":
+			time.Sleep(200 * time.Millisecond)
+			score = 100
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":{"ipAddress":%q,"abuseConfidenceScore":%d}}`, r.URL.Query().Get("ipAddress"), score)
+	}))
+	defer server.Close()
+
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.AbuseIPDBToken = "test-token"
+	config.AbuseIPDBApi = server.URL
+	config.Parameter.ScoreAbuseIPDB = 100
+
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	// 信賴分數 100 直接達到封鎖分數
+	result := guardian.Check(createTestRequest("198.51.100.66"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonScoreBlock, result.Reason)
+
+	// 正常 IP 通過，且結果已快取
+	for n := 0; n < 2; n++ {
+		result = guardian.Check(createTestRequest("198.51.100.67"), httptest.NewRecorder())
+		assert.True(t, result.Success)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// 查詢失敗後暫停查詢，不對每個請求重試
+	result = guardian.Check(createTestRequest("198.51.100.68"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	result = guardian.Check(createTestRequest("198.51.100.69"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	// 額度用盡記錄於 Store，共用 Store 的實例皆停止查詢
+	store := golangIPSentry.NewMemoryStore()
+	config.Store = store
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	a, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(a)
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	b, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(b)
+
+	a.Check(createTestRequest("198.51.100.70"), httptest.NewRecorder())
+	b.Check(createTestRequest("198.51.100.71"), httptest.NewRecorder())
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+
+	// 評分器逾時不觸發暫停查詢，逾時可另外設定
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.AbuseIPDBTimeout = 50 * time.Millisecond
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	c, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(c)
+
+	result = c.Check(createTestRequest("198.51.100.72"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	backoff, err := config.Store.Exists(context.Background(), "abuseipdb:backoff")
+	require.NoError(t, err)
+	assert.False(t, backoff)
+	c.Check(createTestRequest("198.51.100.73"), httptest.NewRecorder())
+	assert.Equal(t, int32(6), atomic.LoadInt32(&hits))
+
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.AbuseIPDBTimeout = time.Second
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	d, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(d)
+
+	result = d.Check(createTestRequest("198.51.100.72"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonScoreBlock, result.Reason)
+}
+
+type testScorer struct {
+	name  string
+	score int
+	delay time.Duration
+	calls int32
+}
+
+func (s *testScorer) Name() string {
+	return s.name
+}
+
+func (s *testScorer) Score(ctx context.Context, device *golangIPSentry.Device, flags *[]string, score *golangIPSentry.RiskScore) error {
+	atomic.AddInt32(&s.calls, 1)
+
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	*flags = append(*flags, s.name)
+	score.Base += s.score
+	score.Detail["ip"] = device.IP.Address
+	return nil
+}
+
+// TestRateLimitAlgorithms 測試速率限制演算法
+func TestRateLimitAlgorithms(t *testing.T) {
+	store := golangIPSentry.NewMemoryStore()
+	defer store.Close()
+	ctx := context.Background()
+
+	limit := golangIPSentry.RateLimit{Limit: 3, Burst: 1, Window: 200 * time.Millisecond}
+	algorithms := []string{
+		golangIPSentry.RateLimitSlidingLog,
+		golangIPSentry.RateLimitSlidingCounter,
+		golangIPSentry.RateLimitGCRA,
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key := "rate:test:" + algorithm
+
+			// Limit + Burst 內皆允許
+			for n := 1; n <= 4; n++ {
+				result, err := store.RateLimit(ctx, key, algorithm, limit, true)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed, "請求 %d 應該允許", n)
+				assert.Equal(t, 4-n, result.Remaining)
+			}
+
+			result, err := store.RateLimit(ctx, key, algorithm, limit, true)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 4, result.Limit)
+			assert.Greater(t, result.RetryAfter, time.Duration(0))
+			assert.LessOrEqual(t, result.RetryAfter, 2*limit.Window)
+
+			// 查詢不計入請求
+			peek, err := store.RateLimit(ctx, key, algorithm, limit, false)
+			require.NoError(t, err)
+			assert.Equal(t, result.Count, peek.Count)
+
+			// 等待後恢復
+			time.Sleep(result.RetryAfter + 10*time.Millisecond)
+			result, err = store.RateLimit(ctx, key, algorithm, limit, true)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+
+	_, err := store.RateLimit(ctx, "rate:test:unknown", "fixed", limit, true)
+	assert.Error(t, err)
+
+	// 數字為每分鐘請求數，物件可指定 burst 與 window
+	var parameter golangIPSentry.Parameter
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"rate_limit_normal": 100,
+		"rate_limit_suspicious": {"limit": 10, "burst": 5, "window": "10s"},
+		"rate_limit_dangerous": {"limit": 1, "window": 1000000000}
+	}`), &parameter))
+	assert.Equal(t, golangIPSentry.RateLimit{Limit: 100, Window: time.Minute}, parameter.RateLimitNormal)
+	assert.Equal(t, golangIPSentry.RateLimit{Limit: 10, Burst: 5, Window: 10 * time.Second}, parameter.RateLimitSuspicious)
+	assert.Equal(t, golangIPSentry.RateLimit{Limit: 1, Window: time.Second}, parameter.RateLimitDangerous)
+
+	// 超過正常速率限制時拒絕
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Parameter.RateLimitAlgorithm = golangIPSentry.RateLimitGCRA
+	config.Parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 2, Window: time.Minute}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	for n := 0; n < 2; n++ {
+		assert.True(t, guardian.Check(createTestRequest("198.51.100.150"), httptest.NewRecorder()).Success)
+	}
+	checked := guardian.Check(createTestRequest("198.51.100.150"), httptest.NewRecorder())
+	assert.False(t, checked.Success)
+	assert.Equal(t, golangIPSentry.ReasonRateNormal, checked.Reason)
+	require.NotNil(t, checked.RateLimit)
+	assert.Greater(t, checked.RateLimit.RetryAfter, time.Duration(0))
+
+	config.Parameter.RateLimitAlgorithm = "fixed"
+	_, err = golangIPSentry.New(config)
+	assert.Error(t, err)
+}
+
+// TestRoutePolicy 測試路由政策
+func TestRoutePolicy(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Routes = []golangIPSentry.RoutePolicy{
+		{Path: "/healthz", Skip: true},
+		{Name: "login", Methods: []string{"post"}, Regex: "^/login$", RateLimitNormal: &golangIPSentry.RateLimit{Limit: 2}},
+		{Glob: "/static/*", Scorers: []string{"basic"}, ScoreSuspicious: 90},
+		{Path: "/open", ScoreSuspicious: golangIPSentry.Disabled, ScoreDangerous: golangIPSentry.Disabled},
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	request := func(method, path string) *http.Request {
+		req := createTestRequest("198.51.100.160")
+		req.Method = method
+		req.URL.Path = path
+		return req
+	}
+
+	// 健康檢查略過所有檢查，不設定 Cookie
+	w := httptest.NewRecorder()
+	result := guardian.Check(request("GET", "/healthz/live"), w)
+	assert.True(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonRouteSkip, result.Reason)
+	assert.Equal(t, "/healthz", result.Route)
+	assert.Empty(t, w.Result().Cookies())
+
+	// 登入路由使用獨立的速率限制
+	for n := 0; n < 2; n++ {
+		result = guardian.Check(request("POST", "/login"), httptest.NewRecorder())
+		assert.True(t, result.Success)
+		assert.Equal(t, "login", result.Route)
+	}
+	result = guardian.Check(request("POST", "/login"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonRateNormal, result.Reason)
+
+	// 方法不符時使用全域設定
+	result = guardian.Check(request("GET", "/login"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Empty(t, result.Route)
+
+	// 僅執行指定的評分器
+	result = guardian.Check(request("GET", "/static/app.js"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Equal(t, "/static/*", result.Route)
+	assert.NotContains(t, result.Detail, "abuseipdb")
+
+	// 中介層套用路由政策
+	handler := guardian.HTTPMiddleware(http.HandlerFunc(testHandler))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request("POST", "/login"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// 路由可停用分數門檻，高分請求仍使用正常級距
+	require.NoError(t, guardian.RegisterScorer(&testScorer{name: "risk", score: 65}, 0))
+	scored := func(ip, path string) golangIPSentry.IPGuardianResult {
+		req := createTestRequest(ip)
+		req.URL.Path = path
+		result := guardian.Check(req, httptest.NewRecorder())
+		require.True(t, result.Success)
+		require.GreaterOrEqual(t, result.Score, 60)
+		return result
+	}
+	result = scored("198.51.100.161", "/open")
+	assert.Equal(t, "/open", result.Route)
+	assert.Equal(t, config.Parameter.RateLimitNormal.Limit, result.RateLimit.Limit)
+	result = scored("198.51.100.162", "/other")
+	assert.Equal(t, config.Parameter.RateLimitDangerous.Limit, result.RateLimit.Limit)
+
+	// 無效的路由設定
+	for _, routes := range [][]golangIPSentry.RoutePolicy{
+		{{Path: "/a", Glob: "/b/*"}},
+		{{Regex: "("}},
+		{{Glob: "["}},
+		{{Path: "/a"}, {Path: "/a"}},
+		{{Path: "/a", RateLimitNormal: &golangIPSentry.RateLimit{}}},
+		{{Path: "/a", RateLimitNormal: &golangIPSentry.RateLimit{Limit: golangIPSentry.Disabled}}},
+		{{Path: "/a", ScoreDangerous: -2}},
+	} {
+		config.Routes = routes
+		_, err := golangIPSentry.New(config)
+		assert.Error(t, err)
+	}
+}
+
+// TestRateLimitHeaders 測試速率限制回應標頭
+func TestRateLimitHeaders(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.RateLimitHeaders = true
+	config.Parameter.RateLimitAlgorithm = golangIPSentry.RateLimitSlidingLog
+	config.Parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 2, Window: 30 * time.Second}
+	config.Parameter.RateLimitSuspicious = golangIPSentry.RateLimit{Limit: 2, Window: 30 * time.Second}
+	config.Parameter.RateLimitDangerous = golangIPSentry.RateLimit{Limit: 2, Window: 30 * time.Second}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	handler := guardian.HTTPMiddleware(http.HandlerFunc(testHandler))
+
+	// 通過時附帶剩餘額度
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, createTestRequest("198.51.100.170"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=30", w.Header().Get("RateLimit-Policy"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), createTestRequest("198.51.100.170"))
+
+	// 超過限制回傳 429 與 Retry-After
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, createTestRequest("198.51.100.170"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.True(t, retryAfter > 0 && retryAfter <= 30)
+	reset, err := strconv.Atoi(w.Header().Get("RateLimit-Reset"))
+	require.NoError(t, err)
+	assert.True(t, reset > 0 && reset <= 30)
+
+	// 封鎖時 Retry-After 為剩餘封鎖時間
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.171", "test"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, createTestRequest("198.51.100.171"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	retryAfter, err = strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, testConfig.Parameter.BlockTimeMin.Seconds(), retryAfter, 2)
+
+	// 未啟用時通過的回應不附帶標頭
+	guardian.Config.RateLimitHeaders = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, createTestRequest("198.51.100.172"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+// TestErrorHandler 測試拒絕回應格式
+func TestErrorHandler(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	banIP := "198.51.100.180"
+	require.NoError(t, guardian.Manager.Deny.Add(banIP, "test"))
+	handler := guardian.HTTPMiddleware(http.HandlerFunc(testHandler))
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := createTestRequest(banIP)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// 預設為 RFC 7807，不洩漏 IP
+	w := serve("")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	var problem golangIPSentry.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, http.StatusForbidden, problem.Status)
+	assert.Equal(t, golangIPSentry.ReasonDenyList, problem.Reason)
+	assert.NotContains(t, w.Body.String(), banIP)
+
+	// 依 Accept 選擇 HTML 或純文字
+	w = serve("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "<h1>Forbidden</h1>")
+	assert.NotContains(t, w.Body.String(), banIP)
+
+	w = serve("text/plain")
+	assert.Equal(t, "Forbidden\n", w.Body.String())
+
+	w = serve("text/html;q=0.5, application/json")
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	// 自訂處理函式
+	guardian.Config.ErrorHandler = func(w http.ResponseWriter, r *http.Request, result golangIPSentry.IPGuardianResult) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	assert.Equal(t, http.StatusNotFound, serve("").Code)
+
+	assert.Equal(t, "Device is banned", golangIPSentry.PublicError(golangIPSentry.IPGuardianResult{Error: "Device is banned, IP: 198.51.100.180"}))
+	assert.Equal(t, "Unknown host [redacted]", golangIPSentry.PublicError(golangIPSentry.IPGuardianResult{Error: "Unknown host 2001:db8::1"}))
+}
+
+// TestLoadConfig 測試設定檔載入與驗證
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+mode: monitor
+redis:
+  host: redis.internal
+trusted_proxies: ["10.0.0.0/8"]
+parameter:
+  block_time_min: 15m
+  block_time_max: 2h
+  high_risk_country: [CN, RU]
+  rate_limit_normal: 120
+  rate_limit_suspicious:
+    limit: 30
+    window: 30s
+filepath:
+  reload_interval: 5s
+`), 0644))
+
+	t.Setenv("IPSENTRY_REDIS_PORT", "6380")
+	t.Setenv("IPSENTRY_PARAMETER_BLOCK_TIME_MIN", "20m")
+	t.Setenv("IPSENTRY_PARAMETER_HIGH_RISK_COUNTRY", "KP, IR")
+	t.Setenv("IPSENTRY_PARAMETER_RATE_LIMIT_NORMAL_BURST", "10")
+
+	config, err := golangIPSentry.LoadConfig(yamlPath)
+	require.NoError(t, err)
+	assert.Equal(t, golangIPSentry.ModeMonitor, config.Mode)
+	assert.Equal(t, "redis.internal", config.Redis.Host)
+	assert.Equal(t, 6380, config.Redis.Port)
+	assert.Equal(t, 20*time.Minute, config.Parameter.BlockTimeMin)
+	assert.Equal(t, 2*time.Hour, config.Parameter.BlockTimeMax)
+	assert.Equal(t, []string{"KP", "IR"}, config.Parameter.HighRiskCountry)
+	// 檔案中的數字寫法保留 limit，環境變數只覆寫 burst
+	assert.Equal(t, 120, config.Parameter.RateLimitNormal.Limit)
+	assert.Equal(t, 10, config.Parameter.RateLimitNormal.Burst)
+	assert.Equal(t, 30*time.Second, config.Parameter.RateLimitSuspicious.Window)
+	assert.Equal(t, 5*time.Second, config.Filepath.ReloadInterval)
+
+	// JSON 同樣接受可讀的時間格式
+	jsonPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"parameter": {"notify_backoff": "1.5s"}}`), 0644))
+	config, err = golangIPSentry.LoadConfig(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, config.Parameter.NotifyBackoff)
+
+	// 彙整所有錯誤，包含環境變數覆寫的值
+	t.Setenv("IPSENTRY_PARAMETER_HIGH_RISK_COUNTRY", "China")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+		"parameter": {
+			"block_time_max": "forever",
+			"block_to_ban": -2,
+			"score_suspicious": 80,
+			"score_dangerous": 50
+		}
+	}`), 0644))
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	require.Error(t, err)
+	for _, field := range []string{"block_time_max", "high_risk_country", "block_to_ban", "score_suspicious"} {
+		assert.Contains(t, err.Error(), field)
+	}
+
+	// 檔案中不是物件的欄位無法以環境變數覆寫子欄位
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"redis": "redis.internal"}`), 0644))
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	assert.ErrorContains(t, err, "IPSENTRY_REDIS_PORT: redis is not an object in the config file")
+
+	// 環境變數格式錯誤
+	t.Setenv("IPSENTRY_REDIS_PORT", "not a port")
+	_, err = golangIPSentry.LoadConfig("")
+	assert.ErrorContains(t, err, "IPSENTRY_REDIS_PORT")
+
+	// 未設定的門檻以預設值比較
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"parameter": {"score_suspicious": 90}}`), 0644))
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	assert.ErrorContains(t, err, "score_suspicious: 90 is greater than score_dangerous 80")
+
+	// 速率限制等級與時間長度不支援 Disabled
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"parameter": {"rate_limit_normal": -1, "block_time_max": -1}}`), 0644))
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	assert.ErrorContains(t, err, "rate_limit_normal: Disabled is not supported by rate limit tiers")
+	assert.ErrorContains(t, err, "block_time_max: Disabled is only supported by thresholds and scores")
+
+	// 未知欄位，保留已收集的錯誤
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"parameterz": {}}`), 0644))
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	assert.ErrorContains(t, err, "parameterz")
+	assert.ErrorContains(t, err, "IPSENTRY_REDIS_PORT")
+	t.Setenv("IPSENTRY_REDIS_PORT", "6379")
+	_, err = golangIPSentry.LoadConfig(jsonPath)
+	assert.Error(t, err)
+
+	// New 同樣驗證設定
+	invalid := testConfig
+	invalid.Parameter.HighRiskCountry = []string{"cn"}
+	_, err = golangIPSentry.New(invalid)
+	assert.Error(t, err)
+}
+
+// TestUpdatePolicy 測試執行中更新策略，並經由共用儲存同步至其他實例
+func TestUpdatePolicy(t *testing.T) {
+	var audit bytes.Buffer
+	store := golangIPSentry.NewMemoryStore()
+	newGuardian := func(withAudit bool) *golangIPSentry.IPGuardian {
+		config := testConfig
+		config.Store = store
+		config.Admin = &golangIPSentry.AdminConfig{Token: "admin-token"}
+		config.Filepath = golangIPSentry.Filepath{
+			WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+			BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+		}
+		if withAudit {
+			config.Audit = &golangIPSentry.AuditConfig{Writer: &audit}
+		}
+		guardian, err := golangIPSentry.New(config)
+		require.NoError(t, err)
+		return guardian
+	}
+
+	a := newGuardian(true)
+	defer teardownTestGuardian(a)
+	b := newGuardian(false)
+	defer teardownTestGuardian(b)
+	assert.Equal(t, int64(0), a.PolicyVersion())
+
+	// 驗證失敗時不更新
+	parameter := a.Policy()
+	parameter.ScoreSuspicious = 90
+	_, err := a.UpdatePolicy(parameter, "ops")
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), a.PolicyVersion())
+
+	// 更新帶有版本與變更紀錄
+	parameter = a.Policy()
+	parameter.ScoreSuspicious = 20
+	parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 1, Window: time.Minute}
+	update, err := a.UpdatePolicy(parameter, "ops")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), update.Version)
+	assert.Equal(t, "ops", update.Actor)
+	fields := []string{}
+	for _, change := range update.Changes {
+		fields = append(fields, change.Field)
+	}
+	assert.ElementsMatch(t, []string{"score_suspicious", "rate_limit_normal"}, fields)
+	assert.Equal(t, 20, a.Policy().ScoreSuspicious)
+	assert.Contains(t, audit.String(), `"action":"policy"`)
+	assert.Contains(t, audit.String(), `"actor":"ops"`)
+
+	// 其他實例經由發布收到更新
+	assert.Eventually(t, func() bool {
+		return b.PolicyVersion() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 20, b.Policy().ScoreSuspicious)
+	assert.True(t, b.Check(createTestRequest("198.51.100.170"), httptest.NewRecorder()).Success)
+	assert.Equal(t, http.StatusTooManyRequests, b.Check(createTestRequest("198.51.100.170"), httptest.NewRecorder()).StatusCode)
+
+	// 新實例啟動時載入最新策略
+	c := newGuardian(false)
+	defer teardownTestGuardian(c)
+	assert.Equal(t, int64(1), c.PolicyVersion())
+	assert.Equal(t, 1, c.Policy().RateLimitNormal.Limit)
+
+	// 管理 API 只更新指定欄位
+	handler := a.AdminHandler()
+	req := httptest.NewRequest("PATCH", "/policy", strings.NewReader(`{"block_time_min":"5m"}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Eventually(t, func() bool {
+		return b.PolicyVersion() == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 5*time.Minute, b.Policy().BlockTimeMin)
+	assert.Equal(t, 20, b.Policy().ScoreSuspicious)
+
+	req = httptest.NewRequest("PATCH", "/policy", strings.NewReader(`{"score_dangerous":10}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	history, err := a.PolicyHistory()
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "admin:token", history[0].Actor)
+	assert.Equal(t, "ops", history[1].Actor)
+
+	// 較舊版本晚寫入時不覆蓋較新版本：版本 4 分配給 a，版本 5 的實例先寫入
+	_, err = store.Incr(context.Background(), "policy:version")
+	require.NoError(t, err)
+	newer := golangIPSentry.PolicyUpdate{Version: 5, Actor: "other", Parameter: a.Policy()}
+	newer.Parameter.ScoreSuspicious = 25
+	data, err := json.Marshal(newer)
+	require.NoError(t, err)
+	saved, err := store.SetVersion(context.Background(), "policy:current", "policy:current:version", 5, data)
+	require.NoError(t, err)
+	require.True(t, saved)
+
+	parameter = a.Policy()
+	parameter.ScoreSuspicious = 35
+	_, err = a.UpdatePolicy(parameter, "ops")
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), a.PolicyVersion())
+	assert.Equal(t, 25, a.Policy().ScoreSuspicious)
+	current, err := store.Get(context.Background(), "policy:current")
+	require.NoError(t, err)
+	assert.Contains(t, current, `"version":5`)
+	history, err = a.PolicyHistory()
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+	_, err = store.Incr(context.Background(), "policy:version")
+	require.NoError(t, err)
+
+	// 沒有 PubSub 且停用清單重載時仍輪詢策略
+	config := testConfig
+	config.Store = plainStore{store}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList:      filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList:      filepath.Join(t.TempDir(), "blackList.json"),
+		ReloadInterval: -1,
+	}
+	config.PolicyInterval = 20 * time.Millisecond
+	d, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(d)
+	assert.Equal(t, int64(5), d.PolicyVersion())
+
+	parameter = a.Policy()
+	parameter.ScoreSuspicious = 40
+	update, err = a.UpdatePolicy(parameter, "ops")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return d.PolicyVersion() == update.Version
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 40, d.Policy().ScoreSuspicious)
+
+	// 管理 API 以原始設定合併，未設定的欄位不寫入預設值
+	config = testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Admin = &golangIPSentry.AdminConfig{Token: "admin-token"}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	config.Parameter = golangIPSentry.Parameter{LoginFailure: golangIPSentry.Disabled, NotFound404: 2}
+	e, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(e)
+	req = httptest.NewRequest("PATCH", "/policy", strings.NewReader(`{"block_time_min":"5m"}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	e.AdminHandler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	history, err = e.PolicyHistory()
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, golangIPSentry.Parameter{
+		LoginFailure: golangIPSentry.Disabled,
+		NotFound404:  2,
+		BlockTimeMin: 5 * time.Minute,
+	}, history[0].Parameter)
+	assert.Equal(t, 80, e.Policy().ScoreDangerous)
+}
+
+// 只實作 Store，不提供 PubSub
+type plainStore struct {
+	golangIPSentry.Store
+}
+
+// TestPolicy 測試預設值於 New 時解析一次，請求不修改共用設定
+func TestPolicy(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	config.Parameter = golangIPSentry.Parameter{
+		LoginFailure:        golangIPSentry.Disabled,
+		NotFound404:         2,
+		ScoreFpMultiSession: golangIPSentry.Disabled,
+	}
+	parameter := config.Parameter
+
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	// 未設定的值使用預設，停用的值回報為 Disabled
+	policy := guardian.Policy()
+	assert.Equal(t, 8, policy.BlockToBan)
+	assert.Equal(t, 50, policy.ScoreSuspicious)
+	assert.Equal(t, 80, policy.ScoreDangerous)
+	assert.Equal(t, 30*time.Minute, policy.BlockTimeMin)
+	assert.Equal(t, 30*time.Hour, policy.BlockTimeMax)
+	assert.Equal(t, 100, policy.RateLimitNormal.Limit)
+	assert.Equal(t, 3, policy.NotifyRetry)
+	assert.Equal(t, 2, policy.NotFound404)
+	assert.Equal(t, golangIPSentry.Disabled, policy.LoginFailure)
+	assert.Equal(t, golangIPSentry.Disabled, policy.ScoreFpMultiSession)
+
+	// 同一 Session 的 404 與登入失敗，停用的登入失敗檢查不計分
+	w := httptest.NewRecorder()
+	guardian.Check(createTestRequest("198.51.100.140"), w)
+	cookies := w.Result().Cookies()
+	request := func() *http.Request {
+		req := createTestRequest("198.51.100.140")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+	for n := 0; n < 10; n++ {
+		require.NoError(t, guardian.NotFound404(httptest.NewRecorder(), request()))
+		require.NoError(t, guardian.LoginFailure(httptest.NewRecorder(), request()))
+	}
+	result := guardian.Check(request(), httptest.NewRecorder())
+	assert.Contains(t, result.Flags, "excessive_404_errors")
+	assert.NotContains(t, result.Flags, "excessive_login_failures")
+
+	// 併發請求不寫入 Config，搭配 -race 檢查
+	var wg sync.WaitGroup
+	for n := 0; n < 16; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			guardian.Check(createTestRequest(fmt.Sprintf("198.51.100.%d", 150+n)), httptest.NewRecorder())
+		}(n)
+	}
+	wg.Wait()
+	assert.Equal(t, parameter, guardian.Config.Parameter)
+
+	// 只有可停用的整數欄位接受 Disabled
+	valid := golangIPSentry.Config{Parameter: golangIPSentry.Parameter{SessionMultiIP: golangIPSentry.Disabled, ScoreAbuseIPDB: golangIPSentry.Disabled}}
+	assert.NoError(t, valid.Validate())
+	for _, invalid := range []golangIPSentry.Parameter{
+		{SessionMultiIP: -2},
+		{BlockTimeMin: golangIPSentry.Disabled},
+		{ScoreNormal: golangIPSentry.Disabled},
+	} {
+		config := golangIPSentry.Config{Parameter: invalid}
+		assert.Error(t, config.Validate())
+	}
+}
+
+// failingStore 模擬 Redis 中斷
+type failingStore struct {
+	*golangIPSentry.MemoryStore
+	down   atomic.Bool
+	broken atomic.Bool // * Store 有回應但回傳錯誤，例如腳本錯誤
+	calls  atomic.Int32
+}
+
+func (s *failingStore) Get(ctx context.Context, key string) (string, error) {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return "", context.DeadlineExceeded
+	}
+	return s.MemoryStore.Get(ctx, key)
+}
+
+func (s *failingStore) Exists(ctx context.Context, key string) (bool, error) {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return false, context.DeadlineExceeded
+	}
+	return s.MemoryStore.Exists(ctx, key)
+}
+
+func (s *failingStore) RateLimit(ctx context.Context, key string, algorithm string, limit golangIPSentry.RateLimit, consume bool) (*golangIPSentry.RateLimitResult, error) {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return nil, context.DeadlineExceeded
+	}
+	if s.broken.Load() {
+		return nil, fmt.Errorf("ERR Error running script")
+	}
+	return s.MemoryStore.RateLimit(ctx, key, algorithm, limit, consume)
+}
+
+func (s *failingStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if s.down.Load() {
+		return context.DeadlineExceeded
+	}
+	return s.MemoryStore.Set(ctx, key, value, ttl)
+}
+
+func (s *failingStore) Pipeline() golangIPSentry.Pipeline {
+	return &failingPipeline{Pipeline: s.MemoryStore.Pipeline(), store: s}
+}
+
+type failingPipeline struct {
+	golangIPSentry.Pipeline
+	store *failingStore
+}
+
+func (p *failingPipeline) Exec(ctx context.Context) error {
+	if p.store.down.Load() {
+		return context.DeadlineExceeded
+	}
+	return p.Pipeline.Exec(ctx)
+}
+
+// TestFailureMode 測試 Store 無法使用時的處理
+func TestFailureMode(t *testing.T) {
+	newGuardian := func(failure golangIPSentry.FailureConfig) (*golangIPSentry.IPGuardian, *failingStore) {
+		store := &failingStore{MemoryStore: golangIPSentry.NewMemoryStore()}
+		config := testConfig
+		config.Store = store
+		config.Failure = failure
+		config.Parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 2}
+		config.Filepath = golangIPSentry.Filepath{
+			WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+			BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+		}
+		guardian, err := golangIPSentry.New(config)
+		require.NoError(t, err)
+		return guardian, store
+	}
+
+	// 放行並標記降級
+	guardian, store := newGuardian(golangIPSentry.FailureConfig{Mode: golangIPSentry.FailOpen})
+	store.down.Store(true)
+	result := guardian.Check(createTestRequest("198.51.100.150"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.True(t, result.Degraded)
+	assert.Equal(t, golangIPSentry.ReasonFailOpen, result.Reason)
+	teardownTestGuardian(guardian)
+
+	// 拒絕並回傳 503
+	guardian, store = newGuardian(golangIPSentry.FailureConfig{Mode: golangIPSentry.FailClosed})
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.151", "test"))
+	store.down.Store(true)
+	result = guardian.Check(createTestRequest("198.51.100.150"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(t, golangIPSentry.ReasonFailClosed, result.Reason)
+	assert.Equal(t, "Service is temporarily unavailable", golangIPSentry.PublicError(result))
+	// 記憶體中的黑名單仍然生效
+	result = guardian.Check(createTestRequest("198.51.100.151"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonDenyList, result.Reason)
+	assert.False(t, result.Degraded)
+	teardownTestGuardian(guardian)
+
+	// 預設使用本機速率限制
+	guardian, store = newGuardian(golangIPSentry.FailureConfig{})
+	defer teardownTestGuardian(guardian)
+	store.down.Store(true)
+	for n := 0; n < 2; n++ {
+		result = guardian.Check(createTestRequest("198.51.100.152"), httptest.NewRecorder())
+		assert.True(t, result.Success)
+		assert.Equal(t, golangIPSentry.ReasonFailLocal, result.Reason)
+	}
+	w := httptest.NewRecorder()
+	result = guardian.Check(createTestRequest("198.51.100.152"), w)
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.Equal(t, golangIPSentry.ReasonFailLocal, result.Reason)
+	assert.True(t, result.Degraded)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// 連續失敗後斷路，不再呼叫 Store
+	guardian, store = newGuardian(golangIPSentry.FailureConfig{Threshold: 2, Cooldown: 50 * time.Millisecond})
+	defer teardownTestGuardian(guardian)
+	store.down.Store(true)
+	for n := 0; n < 3; n++ {
+		guardian.Check(createTestRequest("198.51.100.153"), httptest.NewRecorder())
+	}
+	calls := store.calls.Load()
+	result = guardian.Check(createTestRequest("198.51.100.153"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonFailLocal, result.Reason)
+	assert.Equal(t, calls, store.calls.Load())
+	assert.False(t, guardian.Manager.Block.IsBlock("198.51.100.153"))
+
+	// 冷卻後恢復
+	store.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	result = guardian.Check(createTestRequest("198.51.100.153"), httptest.NewRecorder())
+	assert.False(t, result.Degraded)
+	assert.Equal(t, golangIPSentry.ReasonPass, result.Reason)
+
+	// 非中斷的錯誤回傳 500，不降級也不斷路
+	store.broken.Store(true)
+	for n := 0; n < 3; n++ {
+		result = guardian.Check(createTestRequest("198.51.100.154"), httptest.NewRecorder())
+		assert.False(t, result.Success)
+		assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
+		assert.Equal(t, golangIPSentry.ReasonCheckError, result.Reason)
+		assert.False(t, result.Degraded)
+	}
+	store.broken.Store(false)
+	result = guardian.Check(createTestRequest("198.51.100.154"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonPass, result.Reason)
+
+	// Store 寫入失敗時，名單不保留未儲存的項目
+	store.down.Store(true)
+	assert.Error(t, guardian.Manager.Deny.Add("198.51.100.155", "test"))
+	assert.Error(t, guardian.Manager.Allow.Add("198.51.100.156", "test"))
+	_, err := guardian.Manager.Deny.Import([]golangIPSentry.IPItem{{IP: "198.51.101.0/24"}})
+	assert.Error(t, err)
+	_, err = guardian.Manager.Allow.Import([]golangIPSentry.IPItem{{IP: "198.51.102.0/24"}})
+	assert.Error(t, err)
+	store.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, guardian.Manager.Deny.Check("198.51.100.155"))
+	assert.False(t, guardian.Manager.Allow.Check("198.51.100.156"))
+	assert.False(t, guardian.Manager.Deny.Check("198.51.101.1"))
+	assert.False(t, guardian.Manager.Allow.Check("198.51.102.1"))
+	item, err := guardian.Manager.Deny.Get("198.51.100.155")
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	// 設定驗證
+	config := golangIPSentry.Config{Failure: golangIPSentry.FailureConfig{Mode: "ignore"}}
+	assert.Error(t, config.Validate())
+}
+
+// TestListReload 測試名單檔案熱重載
+func TestListReload(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList:      filepath.Join(dir, "whiteList.json"),
+		BlackList:      filepath.Join(dir, "blackList.json"),
+		ReloadInterval: 20 * time.Millisecond,
+	}
+	writeList := func(path string, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeList(config.Filepath.WhiteList, `[{"ip":"203.0.113.10","reason":"office"}]`)
+	writeList(config.Filepath.BlackList, `[{"ip":"198.51.100.220","reason":"abuse"}]`)
+
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	require.True(t, guardian.Manager.Allow.Check("203.0.113.10"))
+	require.True(t, guardian.Manager.Deny.Check("198.51.100.220"))
+
+	// 新增與移除同時套用至儲存與快取
+	writeList(config.Filepath.WhiteList, `[{"ip":"203.0.113.0/28","reason":"office"}]`)
+	writeList(config.Filepath.BlackList, `[{"ip":"198.51.100.221","reason":"abuse"}]`)
+	require.Eventually(t, func() bool {
+		return guardian.Manager.Deny.Check("198.51.100.221") && !guardian.Manager.Deny.Check("198.51.100.220")
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		item, _ := guardian.Manager.Allow.Get("203.0.113.10")
+		return item != nil && item.IP == "203.0.113.0/28"
+	}, time.Second, 10*time.Millisecond)
+	exist, err := guardian.Store.Exists(context.Background(), "allow:203.0.113.10")
+	require.NoError(t, err)
+	assert.False(t, exist)
+
+	// 格式錯誤時保留上一版
+	writeList(config.Filepath.BlackList, `[{"ip":"not an ip"}]`)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.221"))
+
+	// 透過 API 寫入的檔案不會被重複載入
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.222", "api"))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.222"))
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.221"))
+
+	// 命令列工具寫入相同檔案，執行中的實例於下次重載套用 CIDR
+	cliConfig := config
+	cliConfig.Filepath.ReloadInterval = -1
+	cli, err := golangIPSentry.New(cliConfig)
+	require.NoError(t, err)
+	defer teardownTestGuardian(cli)
+	assert.Equal(t, config.Filepath.BlackList, cli.Manager.Deny.Path())
+	require.NoError(t, cli.Manager.Deny.Add("192.0.2.0/24", "cli"))
+	assert.Eventually(t, func() bool {
+		return guardian.Manager.Deny.Check("192.0.2.9")
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestAuditLog 測試決策稽核紀錄
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Audit = &golangIPSentry.AuditConfig{Writer: &buf}
+	config.Parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 1}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	require.NoError(t, guardian.Manager.Allow.Add("198.51.100.210", "test"))
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.211", "test"))
+
+	guardian.Check(createTestRequest("198.51.100.210"), httptest.NewRecorder())
+	guardian.Check(createTestRequest("198.51.100.211"), httptest.NewRecorder())
+	// 一般通過不記錄
+	guardian.Check(createTestRequest("198.51.100.212"), httptest.NewRecorder())
+	guardian.Check(createTestRequest("198.51.100.212"), httptest.NewRecorder())
+	// 達可疑門檻的通過依檢查當下的結果記錄
+	require.NoError(t, guardian.RegisterScorer(&testScorer{name: "payment", score: 65}, 0))
+	result := guardian.Check(createTestRequest("198.51.100.214"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.True(t, result.Suspicious)
+	assert.Equal(t, guardian.PolicyVersion(), result.PolicyVersion)
+
+	var entries []golangIPSentry.AuditEntry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry golangIPSentry.AuditEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 4)
+
+	assert.Equal(t, golangIPSentry.AuditTrust, entries[0].Action)
+	assert.Equal(t, "198.51.100.210", entries[0].IP)
+	assert.Equal(t, golangIPSentry.AuditBan, entries[1].Action)
+	assert.Equal(t, http.StatusForbidden, entries[1].StatusCode)
+	assert.Equal(t, golangIPSentry.AuditRateLimit, entries[2].Action)
+	assert.Equal(t, "/", entries[2].Path)
+	assert.NotEmpty(t, entries[2].SessionID)
+	assert.NotEmpty(t, entries[2].Fingerprint)
+	assert.False(t, entries[2].Time.IsZero())
+	assert.Equal(t, golangIPSentry.AuditSuspicious, entries[3].Action)
+	assert.Equal(t, 65, entries[3].Score)
+	assert.Equal(t, result.PolicyVersion, entries[3].PolicyVersion)
+
+	// 預設寫入可輪替的檔案，每行皆為 JSON
+	config.Audit = &golangIPSentry.AuditConfig{Path: t.TempDir()}
+	config.Store = golangIPSentry.NewMemoryStore()
+	fileGuardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	require.NoError(t, fileGuardian.Manager.Deny.Add("198.51.100.213", "test"))
+	fileGuardian.Check(createTestRequest("198.51.100.213"), httptest.NewRecorder())
+	teardownTestGuardian(fileGuardian)
+
+	readAudit := func(path string) []golangIPSentry.AuditEntry {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var entries []golangIPSentry.AuditEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry golangIPSentry.AuditEntry
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	fileEntries := readAudit(filepath.Join(config.Audit.Path, "output.log"))
+	require.Len(t, fileEntries, 1)
+	assert.Equal(t, golangIPSentry.AuditBan, fileEntries[0].Action)
+	assert.Equal(t, "198.51.100.213", fileEntries[0].IP)
+
+	files, err := os.ReadDir(config.Audit.Path)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// 超過大小時輪替，僅保留 MaxBackup 份備份
+	config.Audit = &golangIPSentry.AuditConfig{Path: t.TempDir(), MaxSize: 1, MaxBackup: 1}
+	config.Store = golangIPSentry.NewMemoryStore()
+	rotateGuardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	require.NoError(t, rotateGuardian.Manager.Deny.Add("198.51.100.213", "test"))
+	for n := 0; n < 3; n++ {
+		rotateGuardian.Check(createTestRequest("198.51.100.213"), httptest.NewRecorder())
+	}
+	teardownTestGuardian(rotateGuardian)
+
+	files, err = os.ReadDir(config.Audit.Path)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, file := range files {
+		entries := readAudit(filepath.Join(config.Audit.Path, file.Name()))
+		require.Len(t, entries, 1)
+		assert.Equal(t, golangIPSentry.AuditBan, entries[0].Action)
+	}
+}
+
+// TestRegisterScorer 測試自訂評分器
+func TestRegisterScorer(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	slow := &testScorer{name: "slow", score: 100, delay: time.Second}
+	risk := &testScorer{name: "payment", score: 100}
+
+	// 名稱檢查
+	assert.Error(t, guardian.RegisterScorer(nil, 0))
+	assert.Error(t, guardian.RegisterScorer(&testScorer{name: ""}, 0))
+	assert.Error(t, guardian.RegisterScorer(&testScorer{name: "geo"}, 0))
+
+	require.NoError(t, guardian.RegisterScorer(slow, 50*time.Millisecond))
+	assert.Error(t, guardian.RegisterScorer(&testScorer{name: "slow"}, 0))
+
+	// 逾時的評分器被略過，不影響結果
+	result := guardian.Check(createTestRequest("198.51.100.80"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slow.calls))
+	assert.False(t, guardian.Manager.Block.IsBlock("198.51.100.80"))
+
+	// 自訂分數計入總分
+	require.NoError(t, guardian.RegisterScorer(risk, 0))
+	result = guardian.Check(createTestRequest("198.51.100.81"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonScoreBlock, result.Reason)
+	assert.Equal(t, 100, result.Score)
+
+	// 內建評分器同樣套用預設逾時，並停止存取 Store
+	store := &slowStore{MemoryStore: golangIPSentry.NewMemoryStore()}
+	config := testConfig
+	config.Store = store
+	config.Failure = golangIPSentry.FailureConfig{Timeout: 5 * time.Second}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	slowGuardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(slowGuardian)
+
+	start := time.Now()
+	result = slowGuardian.Check(createTestRequest("198.51.100.82"), httptest.NewRecorder())
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.True(t, result.Degraded)
+	assert.Eventually(t, func() bool { return store.canceled.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// 內建評分器的逾時可個別設定
+	config.ScorerTimeout = golangIPSentry.ScorerTimeout{Fingerprint: 50 * time.Millisecond}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	fastGuardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(fastGuardian)
+
+	start = time.Now()
+	result = fastGuardian.Check(createTestRequest("198.51.100.83"), httptest.NewRecorder())
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	assert.True(t, result.Degraded)
+	assert.Eventually(t, func() bool { return store.canceled.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	config.ScorerTimeout = golangIPSentry.ScorerTimeout{Geo: -time.Second}
+	assert.ErrorContains(t, config.Validate(), "scorer_timeout: must not be negative")
+}
+
+// slowStore 模擬回應緩慢的 SCard
+type slowStore struct {
+	*golangIPSentry.MemoryStore
+	canceled atomic.Int32
+}
+
+func (s *slowStore) SCard(ctx context.Context, key string) (int64, error) {
+	select {
+	case <-ctx.Done():
+		s.canceled.Add(1)
+		return 0, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return s.MemoryStore.SCard(ctx, key)
+	}
+}
+
+// TestMonitorMode 測試監控模式只記錄決策
+func TestMonitorMode(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Mode = "unknown"
+	_, err := golangIPSentry.New(config)
+	assert.Error(t, err)
+
+	config.Mode = golangIPSentry.ModeMonitor
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	// 黑名單命中但仍通過
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.90", "test"))
+	result := guardian.Check(createTestRequest("198.51.100.90"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.True(t, result.DryRun)
+	require.NotNil(t, result.Decision)
+	assert.Equal(t, http.StatusForbidden, result.Decision.StatusCode)
+	assert.Equal(t, golangIPSentry.ReasonDenyList, result.Decision.Reason)
+
+	// 分數達到封鎖門檻時不寫入封鎖名單
+	require.NoError(t, guardian.RegisterScorer(&testScorer{name: "payment", score: 100}, 0))
+	result = guardian.Check(createTestRequest("198.51.100.91"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	require.NotNil(t, result.Decision)
+	assert.Equal(t, golangIPSentry.ReasonScoreBlock, result.Decision.Reason)
+	assert.Equal(t, 100, result.Decision.Score)
+	assert.Contains(t, result.Decision.Flags, "payment")
+	assert.False(t, guardian.Manager.Block.IsBlock("198.51.100.91"))
+}
+
+// TestCheckResult 測試檢查結果的判定說明
+func TestCheckResult(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	// 白名單命中
+	require.NoError(t, guardian.Manager.Allow.Add("203.0.113.0/24", "office"))
+	result := guardian.Check(createTestRequest("203.0.113.5"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonAllowList, result.Reason)
+	require.NotNil(t, result.Match)
+	assert.Equal(t, "203.0.113.0/24", result.Match.IP)
+
+	// 白名單命中後不再查詢封鎖名單，也不累計封鎖次數
+	require.NoError(t, guardian.Manager.Block.Add("203.0.113.5", "test"))
+	result = guardian.Check(createTestRequest("203.0.113.5"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonAllowList, result.Reason)
+	exist, err := guardian.Store.Exists(context.Background(), "block:count:203.0.113.5")
+	require.NoError(t, err)
+	assert.False(t, exist)
+
+	// 封鎖名單命中，附剩餘封鎖時間
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.100", "test"))
+	result = guardian.Check(createTestRequest("198.51.100.100"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonBlockList, result.Reason)
+	require.NotNil(t, result.Match)
+	assert.Equal(t, "test", result.Match.Reason)
+	assert.Greater(t, result.BlockTTL, time.Duration(0))
+	assert.NotEmpty(t, result.SessionID)
+	assert.NotEmpty(t, result.Fingerprint)
+
+	// 封鎖期間持續請求達到 BlockToBan 次，轉入黑名單
+	result = guardian.Check(createTestRequest("198.51.100.100"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonBlockList, result.Reason)
+	assert.False(t, guardian.Manager.Deny.Check("198.51.100.100"))
+	result = guardian.Check(createTestRequest("198.51.100.100"), httptest.NewRecorder())
+	assert.False(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonBlockToBan, result.Reason)
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.100"))
+	require.NotNil(t, result.Match)
+	assert.Equal(t, "198.51.100.100", result.Match.IP)
+	result = guardian.Check(createTestRequest("198.51.100.100"), httptest.NewRecorder())
+	assert.Equal(t, golangIPSentry.ReasonDenyList, result.Reason)
+
+	// 分數明細包含自訂評分器
+	require.NoError(t, guardian.RegisterScorer(&testScorer{name: "payment", score: 10}, 0))
+	result = guardian.Check(createTestRequest("198.51.100.101"), httptest.NewRecorder())
+	assert.True(t, result.Success)
+	assert.Equal(t, golangIPSentry.ReasonPass, result.Reason)
+	assert.Contains(t, result.Flags, "payment")
+	assert.Equal(t, map[string]interface{}{"ip": "198.51.100.101"}, result.Detail["payment"])
+}
+
+// TestMetrics 測試 Prometheus 指標輸出
+func TestMetrics(t *testing.T) {
+	collector := metrics.New("")
+
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Metrics = collector
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+	collector.Attach(guardian)
+
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.110", "test"))
+	require.NoError(t, guardian.RegisterScorer(&testScorer{name: "payment", score: 10}, 0))
+
+	guardian.Check(createTestRequest("198.51.100.110"), httptest.NewRecorder())
+	guardian.Check(createTestRequest("198.51.100.111"), httptest.NewRecorder())
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Contains(t, body, `ipsentry_checks_total{decision="reject",reason="deny_list"} 1`)
+	assert.Contains(t, body, `ipsentry_checks_total{decision="allow",reason="pass"} 1`)
+	assert.Contains(t, body, `ipsentry_flags_total{flag="payment"} 1`)
+	assert.Contains(t, body, `ipsentry_score_count 1`)
+	assert.Contains(t, body, `ipsentry_phase_duration_seconds_count{phase="device"} 2`)
+	assert.Contains(t, body, `ipsentry_list_size{list="deny"} 1`)
+
+	// 名單數量在刷新間隔內沿用上次結果，不會每次抓取都掃描 Store
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.112", "test"))
+	w = httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `ipsentry_list_size{list="deny"} 1`)
+
+	collector.Attach(guardian)
+	w = httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `ipsentry_list_size{list="deny"} 2`)
+}
+
+// TestAdminHandler 測試管理 API
+func TestAdminHandler(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Admin = &golangIPSentry.AdminConfig{Token: "admin-token"}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	handler := guardian.AdminHandler()
+	request := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// 驗證權杖
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/deny", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/deny", "", "wrong").Code)
+
+	// 新增、讀取、列出、移除
+	w := request("POST", "/deny", `{"ip":"198.51.100.0/24","reason":"attack"}`, "admin-token")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.7"))
+
+	w = request("GET", "/deny/198.51.100.0/24", "", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"attack"`)
+
+	w = request("GET", "/deny?page=1&size=10", "", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/deny/198.51.100.0/24", "", "admin-token").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/deny/198.51.100.0/24", "", "admin-token").Code)
+	assert.False(t, guardian.Manager.Deny.Check("198.51.100.7"))
+
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/block", `{"ip":"10.0.0.0/8"}`, "admin-token").Code)
+	assert.Equal(t, http.StatusCreated, request("POST", "/block", `{"ip":"198.51.100.120","reason":"manual"}`, "admin-token").Code)
+	assert.True(t, guardian.Manager.Block.IsBlock("198.51.100.120"))
+
+	// 查詢計數與清除速率限制
+	for n := 0; n < 3; n++ {
+		guardian.Check(createTestRequest("198.51.100.121"), httptest.NewRecorder())
+	}
+	state, err := guardian.Inspect("198.51.100.121")
+	require.NoError(t, err)
+	assert.Equal(t, 3, state.RequestCount)
+	require.NotNil(t, state.Score)
+
+	w = request("GET", "/ip/198.51.100.121", "", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"request_count":3`)
+
+	w = request("DELETE", "/ip/198.51.100.121/rate-limit", "", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	state, err = guardian.Inspect("198.51.100.121")
+	require.NoError(t, err)
+	assert.Equal(t, 0, state.RequestCount)
+}
+
+// TestIPManagerImport 測試批次匯入名單
+func TestIPManagerImport(t *testing.T) {
+	guardian := setupTestGuardian(t)
+	defer teardownTestGuardian(guardian)
+
+	count, err := guardian.Manager.Deny.Import([]golangIPSentry.IPItem{
+		{IP: "198.51.100.0/24", Reason: "hosting", AddedAt: 1703980800},
+		{IP: "203.0.113.9", Reason: "scanner"},
+		{IP: "invalid", Reason: "skip"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.True(t, guardian.Manager.Deny.Check("198.51.100.1"))
+	assert.True(t, guardian.Manager.Deny.Check("203.0.113.9"))
+
+	item, err := guardian.Manager.Deny.Get("198.51.100.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1703980800), item.AddedAt)
+
+	item, err = guardian.Manager.Deny.Get("203.0.113.9")
+	require.NoError(t, err)
+	assert.NotZero(t, item.AddedAt)
+
+	count, err = guardian.Manager.Allow.Import([]golangIPSentry.IPItem{{IP: "10.0.0.1-10.0.0.9"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.True(t, guardian.Manager.Allow.Check("10.0.0.5"))
+}
+
+type testNotifier struct {
+	events chan golangIPSentry.Event
+}
+
+func (n *testNotifier) Notify(ctx context.Context, event golangIPSentry.Event) error {
+	n.events <- event
+	return nil
+}
+
+// 阻塞至 release 關閉，模擬緩慢的通知端點
+type slowNotifier struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (n *slowNotifier) Notify(ctx context.Context, event golangIPSentry.Event) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	atomic.AddInt32(&n.calls, 1)
+	return nil
+}
+
+// TestNotifier 測試通知重試、簽章與事件篩選
+func TestNotifier(t *testing.T) {
+	var webhookHits, chatHits int32
+	events := make(chan golangIPSentry.Event, 10)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一次回應失敗，驗證重試
+		if atomic.AddInt32(&webhookHits, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		signature := golangIPSentry.SignWebhook("secret", r.Header.Get("X-IPSentry-Timestamp"), body)
+		assert.Equal(t, "sha256="+signature, r.Header.Get("X-IPSentry-Signature"))
+
+		var event golangIPSentry.Event
+		assert.NoError(t, json.Unmarshal(body, &event))
+		events <- event
+	}))
+	defer webhook.Close()
+
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&chatHits, 1)
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Contains(t, body["content"], "198.51.100.130")
+	}))
+	defer chat.Close()
+
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	config.Parameter.NotifyBackoff = 10 * time.Millisecond
+	config.Parameter.AttackThreshold = 3
+	config.Notifiers = []golangIPSentry.NotifierConfig{
+		{Type: "webhook", URL: webhook.URL, Secret: "secret", Events: []string{golangIPSentry.EventBlock, golangIPSentry.EventEscalation}},
+		{Type: "discord", URL: chat.URL, Events: []string{golangIPSentry.EventBan}},
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	// 封鎖事件送至 webhook，失敗後重試
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.130", "test"))
+	select {
+	case event := <-events:
+		assert.Equal(t, golangIPSentry.EventBlock, event.Type)
+		assert.Equal(t, "198.51.100.130", event.IP)
+	case <-time.After(2 * time.Second):
+		t.Fatal("block event not delivered")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&webhookHits))
+
+	// 再次封鎖為升級事件
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.130", "again"))
+	select {
+	case event := <-events:
+		assert.Equal(t, golangIPSentry.EventEscalation, event.Type)
+		assert.Equal(t, 2, event.Count)
+	case <-time.After(2 * time.Second):
+		t.Fatal("escalation event not delivered")
+	}
+
+	// 黑名單事件只送至 discord
+	require.NoError(t, guardian.Manager.Deny.Add("198.51.100.130", "test"))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&chatHits) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&webhookHits))
+
+	// 每分鐘封鎖數達門檻時觸發攻擊事件，含先前封鎖的 IP
+	attack := &testNotifier{events: make(chan golangIPSentry.Event, 10)}
+	require.NoError(t, guardian.RegisterNotifier(attack, golangIPSentry.EventAttack))
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.131", "test"))
+	require.NoError(t, guardian.Manager.Block.Add("198.51.100.132", "test"))
+	select {
+	case event := <-attack.events:
+		assert.Equal(t, golangIPSentry.EventAttack, event.Type)
+		assert.Equal(t, 3, event.Count)
+	case <-time.After(2 * time.Second):
+		t.Fatal("attack event not delivered")
+	}
+
+	// 緩慢的通知端點只排隊有限的事件，超出的事件被丟棄
+	config.Notifiers = nil
+	config.Store = golangIPSentry.NewMemoryStore()
+	slowGuardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(slowGuardian)
+
+	slow := &slowNotifier{release: make(chan struct{})}
+	require.NoError(t, slowGuardian.RegisterNotifier(slow, golangIPSentry.EventBlock))
+	for n := 0; n < 150; n++ {
+		require.NoError(t, slowGuardian.Manager.Block.Add(fmt.Sprintf("10.20.%d.%d", n/256, n%256), "test"))
+	}
+	// Flush 等待排隊中的事件送出，逾時則回報錯誤
+	assert.Error(t, slowGuardian.Flush(50*time.Millisecond))
+	close(slow.release)
+	require.NoError(t, slowGuardian.Flush(2*time.Second))
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&slow.calls), int32(100))
+	assert.Never(t, func() bool {
+		return atomic.LoadInt32(&slow.calls) > 101
+	}, 200*time.Millisecond, 10*time.Millisecond)
+
+	// 未知類型
+	config.Notifiers = []golangIPSentry.NotifierConfig{{Type: "pager"}}
+	_, err = golangIPSentry.New(config)
+	assert.Error(t, err)
+}
+
 // TestLoginFailure 測試登入失敗記錄
+// 最小 SMTP 替身，記錄收件者與信件內容
+type testSMTPServer struct {
+	listener net.Listener
+	rcpts    chan []string
+	data     chan string
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &testSMTPServer{
+		listener: listener,
+		rcpts:    make(chan []string, 10),
+		data:     make(chan string, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *testSMTPServer) serve(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	defer text.Close()
+
+	var rcpts []string
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "RCPT":
+			rcpts = append(rcpts, line)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.rcpts <- rcpts
+			s.data <- string(data)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newTestSMTPServer(t)
+	defer server.listener.Close()
+
+	addr := server.listener.Addr().(*net.TCPAddr)
+	config := &golangIPSentry.EmailConfig{
+		Host: "127.0.0.1",
+		Port: addr.Port,
+		From: "IP Sentry <sentry@example.com>",
+		To:   []string{"ops@example.com"},
+		CC:   []string{"lead@example.com"},
+		BCC:  []string{"audit@example.com"},
+	}
+
+	var dials int32
+	notifier := golangIPSentry.NewSMTPNotifier(config)
+	notifier.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+
+	// 無帳號時不驗證，伺服器未提供 STARTTLS 時以明文傳送
+	err := notifier.Notify(context.Background(), golangIPSentry.Event{
+		Type:   golangIPSentry.EventBan,
+		IP:     "198.51.100.140",
+		Reason: "<script>",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+	// Cc 與 Bcc 皆為收件者
+	rcpts := <-server.rcpts
+	assert.Len(t, rcpts, 3)
+	assert.Contains(t, strings.Join(rcpts, " "), "<audit@example.com>")
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-server.data))
+	require.NoError(t, err)
+	assert.Equal(t, "lead@example.com", msg.Header.Get("Cc"))
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	assert.NotEmpty(t, msg.Header.Get("Date"))
+	assert.NotEmpty(t, msg.Header.Get("Message-ID"))
+	assert.Equal(t, "[IP Sentry] IP 198.51.100.140 has been banned", msg.Header.Get("Subject"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	// 純文字與 HTML 兩個部分，HTML 內容需跳脫
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		types = append(types, part.Header.Get("Content-Type"))
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			assert.Contains(t, string(body), "&lt;script&gt;")
+		} else {
+			assert.Contains(t, string(body), "<script>")
+		}
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+
+	// 指定 STARTTLS 但伺服器不支援時失敗
+	config.TLS = golangIPSentry.EmailTLSStartTLS
+	err = notifier.Notify(context.Background(), golangIPSentry.Event{Type: golangIPSentry.EventBan, IP: "198.51.100.140"})
+	assert.ErrorContains(t, err, "STARTTLS")
+
+	// 未知的 TLS 模式
+	guardianConfig := testConfig
+	guardianConfig.Store = golangIPSentry.NewMemoryStore()
+	guardianConfig.Email = &golangIPSentry.EmailConfig{TLS: "ssl"}
+	_, err = golangIPSentry.New(guardianConfig)
+	assert.Error(t, err)
+}
+
 func TestLoginFailure(t *testing.T) {
 	guardian := setupTestGuardian(t)
 	defer teardownTestGuardian(guardian)
@@ -292,8 +2193,172 @@ func TestMiddleware(t *testing.T) {
 
 		handler.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusForbidden, w.Code)
-		assert.Contains(t, w.Body.String(), "error")
+		assert.Contains(t, w.Body.String(), "detail")
+		assert.NotContains(t, w.Body.String(), banIP)
+	})
+}
+
+// TestAdapters 測試各框架中介層
+func TestAdapters(t *testing.T) {
+	// fiber 測試連線來自 0.0.0.0
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.TrustedProxies = []string{"127.0.0.1/32", "0.0.0.0/32"}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	banIP := "198.51.100.190"
+	require.NoError(t, guardian.Manager.Deny.Add(banIP, "test"))
+
+	gin.SetMode(gin.TestMode)
+	ginRouter := gin.New()
+	ginRouter.Use(ginsentry.Middleware(guardian))
+	ginRouter.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	chiRouter := chi.NewRouter()
+	chiRouter.Use(chisentry.Middleware(guardian))
+	chiRouter.Get("/", testHandler)
+
+	echoServer := echo.New()
+	echoServer.Use(echosentry.Middleware(guardian))
+	echoServer.GET("/", func(c echo.Context) error { return c.String(http.StatusOK, "OK") })
+
+	fiberApp := fiber.New()
+	fiberApp.Use(fibersentry.Middleware(guardian))
+	fiberApp.Get("/", func(c *fiber.Ctx) error { return c.SendString("OK") })
+
+	serve := func(handler http.Handler, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, createTestRequest(ip))
+		return w
+	}
+
+	for name, handler := range map[string]http.Handler{"gin": ginRouter, "chi": chiRouter, "echo": echoServer} {
+		w := serve(handler, "10.0.0.5")
+		assert.Equal(t, http.StatusOK, w.Code, name)
+		assert.Equal(t, "OK", w.Body.String(), name)
+		assert.NotEmpty(t, w.Result().Cookies(), name)
+
+		w = serve(handler, banIP)
+		assert.Equal(t, http.StatusForbidden, w.Code, name)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), name)
+	}
+
+	// fiber 與 fasthttp 沒有 *http.Request
+	resp, err := fiberApp.Test(createTestRequest("10.0.0.5"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Cookies())
+
+	resp, err = fiberApp.Test(createTestRequest(banIP))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), golangIPSentry.ReasonDenyList)
+	assert.NotContains(t, string(body), banIP)
+
+	handler := fasthttpsentry.Middleware(guardian, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("OK")
+	})
+	request := func(ip, accept string) *fasthttp.RequestCtx {
+		var req fasthttp.Request
+		req.SetRequestURI("/")
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("Accept", accept)
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}, nil)
+		handler(ctx)
+		return ctx
+	}
+
+	ctx := request("10.0.0.5", "")
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.NotEmpty(t, ctx.Response.Header.PeekCookie("conn.sess.id"))
+
+	ctx = request(banIP, "text/plain")
+	assert.Equal(t, http.StatusForbidden, ctx.Response.StatusCode())
+	assert.Equal(t, "Forbidden\n", string(ctx.Response.Body()))
+	assert.Contains(t, string(ctx.Response.Header.ContentType()), "text/plain")
+}
+
+// TestGRPCInterceptors 測試 gRPC 攔截器
+func TestGRPCInterceptors(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.Parameter.RateLimitNormal = golangIPSentry.RateLimit{Limit: 1}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	banIP := "198.51.100.200"
+	require.NoError(t, guardian.Manager.Deny.Add(banIP, "test"))
+
+	// 來自受信任代理，以 x-forwarded-for 取得用戶端 IP
+	callContext := func(ip string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345},
+		})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", ip, "user-agent", "grpc-go/1.73.0"))
+	}
+
+	unary := grpcsentry.UnaryServerInterceptor(guardian)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Call"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "OK", nil
+	}
+
+	resp, err := unary(callContext("198.51.100.201"), nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", resp)
+
+	// 超過速率限制回傳 ResourceExhausted 與 RetryInfo
+	_, err = unary(callContext("198.51.100.201"), nil, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if item, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = item
+		}
+	}
+	require.NotNil(t, retryInfo)
+	assert.True(t, retryInfo.RetryDelay.AsDuration() > 0)
+
+	// 黑名單回傳 PermissionDenied，不洩漏 IP
+	interceptor := grpcsentry.StreamServerInterceptor(guardian)
+	stream := &testServerStream{ctx: callContext(banIP)}
+	err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv any, stream grpc.ServerStream) error {
+		return nil
 	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.NotContains(t, err.Error(), banIP)
+
+	// session 由 metadata 回傳
+	assert.NotEmpty(t, stream.header.Get("conn.sess.id"))
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
 }
 
 // TestConcurrentRequests 測試並發請求
@@ -343,40 +2408,71 @@ func TestIPParsing(t *testing.T) {
 	guardian := setupTestGuardian(t)
 	defer teardownTestGuardian(guardian)
 
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.ClientIPHeader = "cf-connecting-ip"
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	cloudflare, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(cloudflare)
+
 	testCases := []struct {
-		name            string
-		headers         map[string]string
-		remoteAddr      string
-		expectedIP      string
-		expectedPrivate bool
+		name       string
+		guardian   *golangIPSentry.IPGuardian
+		headers    map[string]string
+		remoteAddr string
+		expectedIP string
 	}{
 		{
-			name:            "Cloudflare IP",
-			headers:         map[string]string{"CF-Connecting-IP": "203.64.1.1"},
-			remoteAddr:      "127.0.0.1:12345",
-			expectedIP:      "203.64.1.1",
-			expectedPrivate: false,
+			name:       "X-Forwarded-For",
+			guardian:   guardian,
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			remoteAddr: "127.0.0.1:12345",
+			expectedIP: "192.168.1.100",
+		},
+		{
+			name:       "未設定時忽略單值標頭",
+			guardian:   guardian,
+			headers:    map[string]string{"CF-Connecting-IP": "203.64.1.1", "X-Real-IP": "1.2.3.4"},
+			remoteAddr: "127.0.0.1:12345",
+			expectedIP: "127.0.0.1",
 		},
 		{
-			name:            "X-Forwarded-For",
-			headers:         map[string]string{"X-Forwarded-For": "192.168.1.100"},
-			remoteAddr:      "127.0.0.1:12345",
-			expectedIP:      "192.168.1.100",
-			expectedPrivate: true,
+			name:     "偽造 X-Client-IP 以 X-Forwarded-For 為準",
+			guardian: guardian,
+			headers: map[string]string{
+				"X-Client-IP":     "192.168.1.100",
+				"X-Forwarded-For": "192.168.1.100, 203.0.113.50",
+			},
+			remoteAddr: "127.0.0.1:12345",
+			expectedIP: "203.0.113.50",
 		},
 		{
-			name:            "X-Real-IP",
-			headers:         map[string]string{"X-Real-IP": "1.2.3.4"},
-			remoteAddr:      "127.0.0.1:12345",
-			expectedIP:      "1.2.3.4",
-			expectedPrivate: false,
+			name:     "只讀取設定的標頭",
+			guardian: cloudflare,
+			headers: map[string]string{
+				"CF-Connecting-IP": "203.64.1.1",
+				"X-Forwarded-For":  "1.2.3.4",
+			},
+			remoteAddr: "127.0.0.1:12345",
+			expectedIP: "203.64.1.1",
 		},
 		{
-			name:            "RemoteAddr only",
-			headers:         map[string]string{},
-			remoteAddr:      "10.0.0.1:12345",
-			expectedIP:      "10.0.0.1",
-			expectedPrivate: true,
+			name:       "不可信任來源不讀取設定的標頭",
+			guardian:   cloudflare,
+			headers:    map[string]string{"CF-Connecting-IP": "203.64.1.1"},
+			remoteAddr: "198.51.100.1:12345",
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "RemoteAddr only",
+			guardian:   guardian,
+			headers:    map[string]string{},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "10.0.0.1",
 		},
 	}
 
@@ -388,6 +2484,106 @@ func TestIPParsing(t *testing.T) {
 			for key, value := range tc.headers {
 				req.Header.Set(key, value)
 			}
+
+			result := tc.guardian.Check(req, httptest.NewRecorder())
+			assert.Equal(t, tc.expectedIP, result.IP)
+		})
+	}
+}
+
+// TestTrustedProxy 測試可信任代理
+func TestTrustedProxy(t *testing.T) {
+	config := testConfig
+	config.Store = golangIPSentry.NewMemoryStore()
+	config.TrustedProxies = []string{"127.0.0.1/32", "192.0.2.0/24"}
+	config.Filepath = golangIPSentry.Filepath{
+		WhiteList: filepath.Join(t.TempDir(), "whiteList.json"),
+		BlackList: filepath.Join(t.TempDir(), "blackList.json"),
+	}
+	guardian, err := golangIPSentry.New(config)
+	require.NoError(t, err)
+	defer teardownTestGuardian(guardian)
+
+	banIP := "203.0.113.9"
+	require.NoError(t, guardian.Manager.Deny.Add(banIP, "測試代理"))
+	// 可信任代理本身被封鎖，用於確認未採用代理位址
+	proxyIP := "192.0.2.10"
+	require.NoError(t, guardian.Manager.Deny.Add(proxyIP, "測試代理"))
+
+	testCases := []struct {
+		name       string
+		header     string
+		value      string
+		remoteAddr string
+		banned     bool
+	}{
+		{
+			name:       "可信任代理的 X-Forwarded-For",
+			header:     "X-Forwarded-For",
+			value:      banIP,
+			remoteAddr: "127.0.0.1:12345",
+			banned:     true,
+		},
+		{
+			name:       "不可信任來源偽造標頭",
+			header:     "X-Forwarded-For",
+			value:      banIP,
+			remoteAddr: "198.51.100.1:12345",
+			banned:     false,
+		},
+		{
+			name:       "由右至左取第一個非代理位址",
+			header:     "X-Forwarded-For",
+			value:      banIP + ", 198.51.100.7",
+			remoteAddr: "127.0.0.1:12345",
+			banned:     false,
+		},
+		{
+			name:       "跳過可信任代理",
+			header:     "X-Forwarded-For",
+			value:      banIP + ", 127.0.0.1",
+			remoteAddr: "127.0.0.1:12345",
+			banned:     true,
+		},
+		{
+			name:       "RFC 7239 Forwarded",
+			header:     "Forwarded",
+			value:      `for="` + banIP + `:4711";proto=https, for=127.0.0.1`,
+			remoteAddr: "127.0.0.1:12345",
+			banned:     true,
+		},
+		{
+			name:       "皆為可信任代理時取最左側位址",
+			header:     "X-Forwarded-For",
+			value:      proxyIP,
+			remoteAddr: "127.0.0.1:12345",
+			banned:     true,
+		},
+		{
+			name:       "可信任代理之後的未知位址改用連線位址",
+			header:     "X-Forwarded-For",
+			value:      "unknown, " + proxyIP,
+			remoteAddr: "127.0.0.1:12345",
+			banned:     false,
+		},
+		{
+			name:       "可信任代理之後的混淆位址改用連線位址",
+			header:     "Forwarded",
+			value:      `for=_hidden, for=` + proxyIP,
+			remoteAddr: "127.0.0.1:12345",
+			banned:     false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set(tc.header, tc.value)
+			w := httptest.NewRecorder()
+
+			result := guardian.Check(req, w)
+			assert.Equal(t, !tc.banned, result.Success)
 		})
 	}
 }
@@ -433,7 +2629,7 @@ func TestUserAgentParsing(t *testing.T) {
 
 // Benchmark 效能測試
 func BenchmarkIPGuardianCheck(b *testing.B) {
-	guardian := setupTestGuardian(&testing.T{})
+	guardian := setupTestGuardian(b)
 	defer teardownTestGuardian(guardian)
 
 	req := createTestRequest("10.0.0.1")
@@ -0,0 +1,209 @@
+package golangIPSentry
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+type ipTrie struct {
+	v4   *trieNode
+	v6   *trieNode
+	size int
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	// * entries covering exactly this prefix, e.g. a CIDR and a range, the last added matches
+	items []*IPItem
+}
+
+func newIPTrie() *ipTrie {
+	return &ipTrie{
+		v4: &trieNode{},
+		v6: &trieNode{},
+	}
+}
+
+func (t *ipTrie) root(addr netip.Addr) *trieNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func (t *ipTrie) insert(prefix netip.Prefix, item *IPItem) {
+	addr := prefix.Addr()
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+
+	for idx := 0; idx < prefix.Bits(); idx++ {
+		bit := (bytes[idx/8] >> (7 - uint(idx%8))) & 1
+		if node.child[bit] == nil {
+			node.child[bit] = &trieNode{}
+		}
+		node = node.child[bit]
+	}
+
+	if len(node.items) == 0 {
+		t.size++
+	}
+	for idx, current := range node.items {
+		if current.IP == item.IP {
+			node.items[idx] = item
+			return
+		}
+	}
+	node.items = append(node.items, item)
+}
+
+// * remove the prefix of the entry, nodes left empty are pruned
+func (t *ipTrie) remove(prefix netip.Prefix, entry string) {
+	addr := prefix.Addr()
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+	path := make([]*trieNode, 0, prefix.Bits()+1)
+	path = append(path, node)
+
+	for idx := 0; idx < prefix.Bits(); idx++ {
+		bit := (bytes[idx/8] >> (7 - uint(idx%8))) & 1
+		node = node.child[bit]
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+
+	found := false
+	for idx, item := range node.items {
+		if item.IP == entry {
+			node.items = append(node.items[:idx], node.items[idx+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found || len(node.items) > 0 {
+		return
+	}
+	t.size--
+
+	for depth := len(path) - 1; depth > 0; depth-- {
+		node := path[depth]
+		if len(node.items) > 0 || node.child[0] != nil || node.child[1] != nil {
+			return
+		}
+		idx := depth - 1
+		bit := (bytes[idx/8] >> (7 - uint(idx%8))) & 1
+		path[depth-1].child[bit] = nil
+	}
+}
+
+func (n *trieNode) item() *IPItem {
+	if len(n.items) == 0 {
+		return nil
+	}
+	return n.items[len(n.items)-1]
+}
+
+// * longest prefix match
+func (t *ipTrie) lookup(addr netip.Addr) *IPItem {
+	addr = addr.Unmap()
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+	match := node.item()
+
+	for idx := 0; idx < addr.BitLen(); idx++ {
+		bit := (bytes[idx/8] >> (7 - uint(idx%8))) & 1
+		node = node.child[bit]
+		if node == nil {
+			break
+		}
+		if item := node.item(); item != nil {
+			match = item
+		}
+	}
+
+	return match
+}
+
+// * accept single IP, CIDR (203.0.113.0/24) or range (10.0.0.1-10.0.0.50)
+// * return normalized entry and the prefixes it covers
+func parseIPEntry(entry string) (string, []netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid CIDR: %s", entry)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked()
+		if prefix.Bits() == prefix.Addr().BitLen() {
+			return prefix.Addr().String(), []netip.Prefix{prefix}, nil
+		}
+		return prefix.String(), []netip.Prefix{prefix}, nil
+	}
+
+	if strings.Contains(entry, "-") {
+		parts := strings.SplitN(entry, "-", 2)
+		start, err1 := netip.ParseAddr(strings.TrimSpace(parts[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(parts[1]))
+		if err1 != nil || err2 != nil {
+			return "", nil, fmt.Errorf("invalid IP range: %s", entry)
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return "", nil, fmt.Errorf("invalid IP range: %s", entry)
+		}
+		return start.String() + "-" + end.String(), rangeToPrefixes(start, end), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid IP: %s", entry)
+	}
+	addr = addr.Unmap()
+
+	return addr.String(), []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// * split range into the minimal set of CIDR blocks
+func rangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	var list []netip.Prefix
+
+	for {
+		bits := start.BitLen()
+		for bits > 0 {
+			prefix := netip.PrefixFrom(start, bits-1).Masked()
+			if prefix.Addr() != start || end.Less(lastAddr(prefix)) {
+				break
+			}
+			bits--
+		}
+
+		prefix := netip.PrefixFrom(start, bits)
+		list = append(list, prefix)
+
+		last := lastAddr(prefix)
+		if last == end {
+			break
+		}
+
+		start = last.Next()
+		if !start.IsValid() {
+			break
+		}
+	}
+
+	return list
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+
+	for idx := prefix.Bits(); idx < len(bytes)*8; idx++ {
+		bytes[idx/8] |= 1 << (7 - uint(idx%8))
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
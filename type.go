@@ -2,11 +2,15 @@ package golangIPSentry
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	goLogger "github.com/pardnchiu/go-logger"
-	"github.com/redis/go-redis/v9"
 )
 
 type Log = goLogger.Log
@@ -18,31 +22,55 @@ const (
 )
 
 const (
-	redisSessionIP    = "session:ip:%s"
-	redisIPDevice     = "ip:device:%s"
-	redisDeviceFp     = "device:fp:%s"
-	redisGeoIP        = "geo:ip:%s"
-	redisGeoLocation  = "geo:locations:%s"
-	redisIntervalLast = "interval:last:%s"
-	redisSessionStart = "session:start:%s"
-	redisFpSession    = "fp:session:%d:%s"
-	redisInterval     = "interval:%s"
-	redisSuspicious   = "suspicious:%d:%s"
-	redisAllow        = "allow:%s"
-	redisDeny         = "deny:%s"
-	redisBlock        = "block:%s"
-	redisBlockCount   = "block:count:%s"
-	redisFrequency    = "frequency:%s:%d"
-	redisLoginFailure = "login:failure:%s"
-	redisNotFound404  = "notfound:404:%s"
+	redisSessionIP        = "session:ip:%s"
+	redisIPDevice         = "ip:device:%s"
+	redisDeviceFp         = "device:fp:%s"
+	redisGeoIP            = "geo:ip:%s"
+	redisGeoLocation      = "geo:locations:%s"
+	redisIntervalLast     = "interval:last:%s"
+	redisSessionStart     = "session:start:%s"
+	redisFpSession        = "fp:session:%d:%s"
+	redisInterval         = "interval:%s"
+	redisSuspicious       = "suspicious:%d:%s"
+	redisAllow            = "allow:%s"
+	redisDeny             = "deny:%s"
+	redisBlock            = "block:%s"
+	redisBlockCount       = "block:count:%s"
+	redisRateLimit        = "rate:%s:%s"
+	redisRateLimitRoute   = "rate:%s:route:%s:%s"
+	redisLoginFailure     = "login:failure:%s"
+	redisNotFound404      = "notfound:404:%s"
+	redisAbuseIPDB        = "abuseipdb:%s"
+	redisAbuseIPDBQuota   = "abuseipdb:quota:%s"
+	redisAbuseIPDBBackoff = "abuseipdb:backoff"
+	redisScoreLast        = "score:last:%s"
+	redisAttack           = "attack:%d"
+	redisPolicy           = "policy:current"
+	redisPolicyVersion    = "policy:version"
+	redisPolicyCurrent    = "policy:current:version"
+	redisPolicyHistory    = "policy:history"
+	redisPolicyChannel    = "policy:update"
 )
 
 const (
-	defaultLogPath       = "./logs/mysqlPool"
-	defaultLogMaxSize    = 16 * 1024 * 1024
-	defaultLogMaxBackup  = 5
-	defaultWhiteListPath = "./whiteList.json"
-	defaultBlackListPath = "./blackList.json"
+	defaultLogPath         = "./logs/mysqlPool"
+	defaultLogMaxSize      = 16 * 1024 * 1024
+	defaultLogMaxBackup    = 5
+	defaultWhiteListPath   = "./whiteList.json"
+	defaultBlackListPath   = "./blackList.json"
+	defaultReloadInterval  = 10 * time.Second
+	defaultPolicyInterval  = 10 * time.Second
+	defaultPageSize        = 50
+	defaultAbuseIPDBApi    = "https://api.abuseipdb.com/api/v2"
+	defaultRateLimitWindow = time.Minute
+	defaultScorerTimeout   = 500 * time.Millisecond
+	defaultPolicyHistory   = 100
+	defaultNotifyQueue     = 100 // * pending events per notifier, newer events are dropped when full
+)
+
+const (
+	ModeEnforce = "enforce" // * reject requests
+	ModeMonitor = "monitor" // * dry-run, only record what would be rejected
 )
 
 var (
@@ -50,6 +78,9 @@ var (
 	secretOnce    sync.Once
 )
 
+// * returned by Remove when the entry does not exist
+var ErrNotFound = errors.New("not found")
+
 var internalIPs = []string{
 	"10.0.0.0/8",
 	"172.16.0.0/12",
@@ -61,32 +92,96 @@ var internalIPs = []string{
 }
 
 type Config struct {
-	Redis     Redis        `json:"redis"`
-	Email     *EmailConfig `json:"email"`
-	Log       *Log         `json:"log"`
-	Filepath  Filepath     `json:"filepath"`
-	Parameter Parameter    `json:"parameter"`
-	// AbuseIPDBToken  string       `json:"abuseipdb_token"`
-	// AbuseIPDBIsPaid bool         `json:"abuseipdb_is_paid"`
+	Redis            Redis            `json:"redis"`
+	Mode             string           `json:"mode"` // * "enforce" or "monitor", default: "enforce"
+	Store            Store            `json:"-"`    // * custom storage backend, default: redis from `Redis`
+	Metrics          Metrics          `json:"-"`    // * metrics hook, default: disabled
+	Email            *EmailConfig     `json:"email"`
+	Log              *Log             `json:"log"`
+	Filepath         Filepath         `json:"filepath"`
+	Parameter        Parameter        `json:"parameter"`
+	TrustedProxies   []string         `json:"trusted_proxies"`  // * trusted proxy IPs/CIDRs, forwarded headers are ignored when empty
+	ClientIPHeader   string           `json:"client_ip_header"` // * the only header read from trusted proxies, e.g. "CF-Connecting-IP", default: X-Forwarded-For then Forwarded
+	AbuseIPDBToken   string           `json:"abuseipdb_token"`
+	AbuseIPDBIsPaid  bool             `json:"abuseipdb_is_paid"`
+	AbuseIPDBApi     string           `json:"abuseipdb_api"`      // * default: https://api.abuseipdb.com/api/v2
+	AbuseIPDBTimeout time.Duration    `json:"abuseipdb_timeout"`  // * timeout of the AbuseIPDB scorer, default: 2s
+	ScorerTimeout    ScorerTimeout    `json:"scorer_timeout"`     // * timeout of the other built-in scorers
+	Admin            *AdminConfig     `json:"admin"`              // * admin API auth, nil rejects every request
+	Notifiers        []NotifierConfig `json:"notifiers"`          // * webhook, slack and discord notifications
+	Routes           []RoutePolicy    `json:"routes"`             // * per-route overrides, first match applies
+	ErrorHandler     ErrorHandler     `json:"-"`                  // * response of rejected requests in the middlewares, default: DefaultErrorHandler
+	RateLimitHeaders bool             `json:"rate_limit_headers"` // * add RateLimit headers to allowed responses, rejections always have them
+	Audit            *AuditConfig     `json:"audit"`              // * JSON line per security decision, nil disables
+	Failure          FailureConfig    `json:"failure"`            // * behavior when the Store is unavailable
+	PolicyInterval   time.Duration    `json:"policy_interval"`    // * poll interval of the shared policy, default: 10s, negative disables
+}
+
+// * default: 500ms, custom scorers set theirs in RegisterScorer
+type ScorerTimeout struct {
+	Basic       time.Duration `json:"basic"`
+	Geo         time.Duration `json:"geo"`
+	Behavior    time.Duration `json:"behavior"`
+	Fingerprint time.Duration `json:"fingerprint"`
+}
+
+type FailureConfig struct {
+	Mode      string        `json:"mode"`      // * "open", "closed" or "local", default: "local"
+	Timeout   time.Duration `json:"timeout"`   // * timeout of each Store call, default: 100ms
+	Threshold int           `json:"threshold"` // * consecutive failures that open the circuit, default: 5
+	Cooldown  time.Duration `json:"cooldown"`  // * time the circuit stays open before a trial call, default: 10s
+}
+
+type AuditConfig struct {
+	Path      string    `json:"path"`       // * directory of output.log, default: Log.Path + "/audit"
+	Stdout    bool      `json:"stdout"`     // * also write to stdout
+	MaxSize   int64     `json:"max_size"`   // * rotate size in bytes, default: Log.MaxSize
+	MaxBackup int       `json:"max_backup"` // * rotated files to keep, default: Log.MaxBackup
+	Writer    io.Writer `json:"-"`          // * custom sink instead of the file, writes are serialized
+}
+
+type AdminConfig struct {
+	Token       string   `json:"token"`        // * bearer token
+	MTLS        bool     `json:"mtls"`         // * accept verified client certificates
+	ClientNames []string `json:"client_names"` // * allowed certificate CN or DNS SAN, empty allows any verified certificate
 }
 
 type Filepath struct {
-	CityDB    string `json:"city_db"`
-	CountryDB string `json:"country_db"`
-	WhiteList string `json:"trust_list"`
-	BlackList string `json:"ban_list"`
+	CityDB         string        `json:"city_db"`
+	CountryDB      string        `json:"country_db"`
+	WhiteList      string        `json:"trust_list"`
+	BlackList      string        `json:"ban_list"`
+	ReloadInterval time.Duration `json:"reload_interval"` // * poll interval of the list files, default: 10s, negative disables
 }
 
 type EmailConfig struct {
 	Host     string                                 `json:"host"`
 	Port     int                                    `json:"port"`
-	Username string                                 `json:"username"`
+	Username string                                 `json:"username"` // * empty skips auth, e.g. local relay
 	Password string                                 `json:"password"`
 	From     string                                 `json:"from"`
 	To       []string                               `json:"to"`
 	CC       []string                               `json:"cc"`
-	Subject  *func(ip string, reason string) string `json:"-"` // default: "[IP Sentry] IP {ip} has been banned"
-	Body     *func(ip string, reason string) string `json:"-"` // default: "[IP Sentry] IP {ip} has been banned for {reason}"
+	BCC      []string                               `json:"bcc"`    // * delivered but not listed in headers
+	TLS      string                                 `json:"tls"`    // * "starttls", "implicit" or "none", default: implicit on port 465, otherwise STARTTLS when offered
+	Subject  *func(ip string, reason string) string `json:"-"`      // default: "[IP Sentry] IP {ip} has been banned"
+	Body     *func(ip string, reason string) string `json:"-"`      // default: "[IP Sentry] IP {ip} has been banned for {reason}"
+	HTML     *func(ip string, reason string) string `json:"-"`      // default: escaped Body in a paragraph
+	Events   []string                               `json:"events"` // default: ["ban"]
+}
+
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "implicit"
+	EmailTLSNone     = "none"
+)
+
+// * Limit requests per Window, up to Limit + Burst at once
+// * a plain number in JSON is Limit per minute
+type RateLimit struct {
+	Limit  int           `json:"limit"`
+	Burst  int           `json:"burst"`
+	Window time.Duration `json:"window"` // default: 1 minute
 }
 
 type Parameter struct {
@@ -94,9 +189,10 @@ type Parameter struct {
 	BlockToBan             int           `json:"block_to_ban"`              // 封鎖到禁止的次數
 	BlockTimeMin           time.Duration `json:"block_time_min"`            // 最小封鎖時間
 	BlockTimeMax           time.Duration `json:"block_time_max"`            // 最大限制時間
-	RateLimitNormal        int           `json:"rate_limit_normal"`         // 正常請求速率限制
-	RateLimitSuspicious    int           `json:"rate_limit_suspicious"`     // 可疑請求速率限制
-	RateLimitDangerous     int           `json:"rate_limit_dangerous"`      // 危險請求速率限制
+	RateLimitAlgorithm     string        `json:"rate_limit_algorithm"`      // 速率限制演算法："sliding_log"、"sliding_counter" 或 "gcra"
+	RateLimitNormal        RateLimit     `json:"rate_limit_normal"`         // 正常請求速率限制
+	RateLimitSuspicious    RateLimit     `json:"rate_limit_suspicious"`     // 可疑請求速率限制
+	RateLimitDangerous     RateLimit     `json:"rate_limit_dangerous"`      // 危險請求速率限制
 	SessionMultiIP         int           `json:"session_multi_ip"`          // 單一 Session 允許的最大 IP 數
 	IPMultiDevice          int           `json:"ip_multi_device"`           // 單一 IP 允許的最大設備數
 	DeviceMultiIP          int           `json:"device_multi_ip"`           // 單一設備允許的最大 IP 數
@@ -118,16 +214,35 @@ type Parameter struct {
 	ScoreLongConnection    int           `json:"score_long_connection"`     // 長連接可疑分數
 	ScoreLoginFailure      int           `json:"score_login_failure"`       // 登入失敗可疑分數
 	ScoreNotFound404       int           `json:"score_not_found_404"`       // 404 請求可疑分數
+	ScoreAbuseIPDB         int           `json:"score_abuseipdb"`           // AbuseIPDB 信賴分數權重（百分比）
+	AttackThreshold        int           `json:"attack_threshold"`          // 每分鐘封鎖 IP 數達到此值時發送攻擊通知，0 為停用
+	NotifyRetry            int           `json:"notify_retry"`              // 通知失敗重試次數
+	NotifyBackoff          time.Duration `json:"notify_backoff"`            // 通知重試間隔，每次加倍
 }
 
 type IPGuardian struct {
-	Context  context.Context
-	Config   *Config
-	Redis    *redis.Client
-	Logger   *Logger
-	GeoLite2 *GeoLite2
-	Manager  *Manager
-	// AbuseIPDBApi *AbuseIPDBApi
+	Context        context.Context
+	Config         *Config
+	Store          Store
+	Logger         *Logger
+	Metrics        Metrics
+	GeoLite2       *GeoLite2
+	Manager        *Manager
+	AbuseIPDBApi   *AbuseIPDBApi
+	policy         atomic.Pointer[policy] // * resolved Parameter, see Policy
+	fallback       Store                  // * in-memory rate limit of FailLocal
+	trustedProxies []netip.Prefix
+	notifier       *notifyDispatcher
+	audit          *auditLog
+	watchStop      chan struct{}
+	watchDone      chan struct{}
+	policyCancel   context.CancelFunc
+	policyDone     chan struct{}
+	pollStop       chan struct{}
+	pollDone       chan struct{}
+	routes         []*route
+	scorers        []ScoreTask
+	scorerMutex    sync.RWMutex
 }
 
 type Manager struct {
@@ -151,13 +266,13 @@ type IPItem struct {
 	Last    int64  `json:"last,omitempty"`
 }
 
-// type AbuseIPDBApi struct {
-// 	Context context.Context
-// 	IsPaid  bool
-// 	Token   string
-// 	Redis   *redis.Client
-// 	HTTP    *http.Client
-// 	Logger  *Logger
-// 	Last    time.Time
-// 	Mutex   sync.Mutex
-// }
+type AbuseIPDBApi struct {
+	Context context.Context
+	IsPaid  bool
+	Token   string
+	BaseURL string
+	Timeout time.Duration // * scorer timeout, default: 2s
+	Store   Store
+	HTTP    *http.Client
+	Logger  *Logger
+}